@@ -0,0 +1,88 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"image"
+	"sync"
+)
+
+// imageCache holds decoded images, least recently used ones are dropped when size limit is reached.
+// Cached images are shared and must not be modified.
+type imageCache struct {
+	mu     sync.Mutex
+	limit  int64
+	size   int64
+	items  map[string]*list.Element
+	order  *list.List
+	hits   int
+	misses int
+}
+
+type imageCacheEntry struct {
+	key  string
+	img  image.Image
+	size int64
+}
+
+func newImageCache(limit int64) *imageCache {
+	return &imageCache{limit: limit, items: make(map[string]*list.Element), order: list.New()}
+}
+
+func (c *imageCache) get(key string) (image.Image, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.order.MoveToFront(e)
+	return e.Value.(*imageCacheEntry).img, true
+}
+
+func (c *imageCache) add(key string, img image.Image) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+	// Size is estimated as 4 bytes per pixel.
+	size := int64(img.Bounds().Dx()) * int64(img.Bounds().Dy()) * 4
+	if size > c.limit {
+		return
+	}
+	c.items[key] = c.order.PushFront(&imageCacheEntry{key, img, size})
+	c.size += size
+	for c.size > c.limit {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *imageCache) remove(e *list.Element) {
+	entry := e.Value.(*imageCacheEntry)
+	c.order.Remove(e)
+	delete(c.items, entry.key)
+	c.size -= entry.size
+}
+
+// reset drops every cached image and clears statistics.
+func (c *imageCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+	c.size = 0
+	c.hits, c.misses = 0, 0
+}
+
+func (c *imageCache) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return fmt.Sprintf("%d hits, %d misses, %d images, %.1f MB", c.hits, c.misses, len(c.items), float64(c.size)/(1<<20))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestImageCache(t *testing.T) {
+	// Every 2x2 image takes 16 bytes.
+	img := func() image.Image { return image.NewRGBA(image.Rect(0, 0, 2, 2)) }
+	c := newImageCache(40)
+
+	a, b := img(), img()
+	c.add("a", a)
+	c.add("b", b)
+	if got, ok := c.get("a"); !ok || got != a {
+		t.Fatal("image a is not cached")
+	}
+
+	// Image b is the least recently used one now, so it is dropped to fit c.
+	c.add("c", img())
+	if _, ok := c.get("b"); ok {
+		t.Error("least recently used image b is not dropped")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Error("recently used image a is dropped")
+	}
+	if _, ok := c.get("c"); !ok {
+		t.Error("image c is not cached")
+	}
+
+	// Images bigger than the limit are not cached.
+	c.add("big", image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	if _, ok := c.get("big"); ok {
+		t.Error("image bigger than the limit is cached")
+	}
+
+	// Adding the same key replaces the image.
+	a2 := img()
+	c.add("a", a2)
+	if got, _ := c.get("a"); got != a2 {
+		t.Error("image a is not replaced")
+	}
+
+	if want := "4 hits, 2 misses, 2 images, 0.0 MB"; c.String() != want {
+		t.Errorf("got %q, want %q", c.String(), want)
+	}
+	if c.size != 32 {
+		t.Errorf("got size %v, want 32", c.size)
+	}
+
+	c.reset()
+	if _, ok := c.get("a"); ok || c.size != 0 || c.hits != 0 {
+		t.Error("cache is not reset")
+	}
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+)
+
+// DDS pixel format flags.
+const (
+	ddpfAlphaPixels = 0x1
+	ddpfAlpha       = 0x2
+	ddpfFourCC      = 0x4
+	ddpfRGB         = 0x40
+	ddpfLuminance   = 0x20000
+)
+
+// ddsPixelFormat describes uncompressed DDS pixels.
+type ddsPixelFormat struct {
+	Flags    uint32
+	BitCount uint32
+	Masks    [4]uint32 // R, G, B, A
+}
+
+// decodeDDS decodes the top mipmap level of DDS image.
+// Supported are BC1-BC5 and BC7 compressed textures, with legacy or DX10 header, and uncompressed RGB, luminance and alpha textures with any channel masks.
+func decodeDDS(r io.Reader) (image.Image, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 128 || !bytes.HasPrefix(b, ddsMagic) {
+		return nil, fmt.Errorf("not a DDS file")
+	}
+
+	le := binary.LittleEndian
+	h := b[4:128]
+	height := int(le.Uint32(h[8:]))
+	width := int(le.Uint32(h[12:]))
+	if width <= 0 || height <= 0 || width > 16384 || height > 16384 {
+		return nil, fmt.Errorf("wrong DDS image size %dx%d", width, height)
+	}
+	pf := ddsPixelFormat{
+		Flags:    le.Uint32(h[76:]),
+		BitCount: le.Uint32(h[84:]),
+		Masks:    [4]uint32{le.Uint32(h[88:]), le.Uint32(h[92:]), le.Uint32(h[96:]), le.Uint32(h[100:])},
+	}
+	data := b[128:]
+
+	if pf.Flags&ddpfFourCC == 0 {
+		return decodeDDSMasked(data, width, height, pf)
+	}
+
+	fourCC := string(h[80:84])
+	switch fourCC {
+	case "DX10":
+		if len(data) < 20 {
+			return nil, fmt.Errorf("DDS DX10 header is too short")
+		}
+		return decodeDXGI(data[20:], width, height, le.Uint32(data))
+	case "DXT1":
+		return decodeDDSBlocks(data, width, height, 8, decodeBC1)
+	// Premultiplied alpha of DXT2 and DXT4 is ignored.
+	case "DXT2", "DXT3":
+		return decodeDDSBlocks(data, width, height, 16, decodeBC2)
+	case "DXT4", "DXT5":
+		return decodeDDSBlocks(data, width, height, 16, decodeBC3)
+	case "ATI1", "BC4U":
+		return decodeDDSBlocks(data, width, height, 8, decodeBC4)
+	case "BC4S":
+		return decodeDDSBlocks(data, width, height, 8, decodeBC4S)
+	case "ATI2", "BC5U":
+		return decodeDDSBlocks(data, width, height, 16, decodeBC5)
+	case "BC5S":
+		return decodeDDSBlocks(data, width, height, 16, decodeBC5S)
+	}
+	return nil, fmt.Errorf("unsupported DDS format %q", fourCC)
+}
+
+// decodeDXGI decodes image data described by DX10 header DXGI_FORMAT value.
+func decodeDXGI(data []byte, width, height int, format uint32) (image.Image, error) {
+	rgba := uint32(ddpfRGB | ddpfAlphaPixels)
+	switch format {
+	case 70, 71, 72:
+		return decodeDDSBlocks(data, width, height, 8, decodeBC1)
+	case 73, 74, 75:
+		return decodeDDSBlocks(data, width, height, 16, decodeBC2)
+	case 76, 77, 78:
+		return decodeDDSBlocks(data, width, height, 16, decodeBC3)
+	case 79, 80:
+		return decodeDDSBlocks(data, width, height, 8, decodeBC4)
+	case 81:
+		return decodeDDSBlocks(data, width, height, 8, decodeBC4S)
+	case 82, 83:
+		return decodeDDSBlocks(data, width, height, 16, decodeBC5)
+	case 84:
+		return decodeDDSBlocks(data, width, height, 16, decodeBC5S)
+	case 94, 95, 96:
+		return nil, fmt.Errorf("BC6H HDR textures are not supported")
+	case 97, 98, 99:
+		return decodeDDSBlocks(data, width, height, 16, decodeBC7)
+	case 27, 28, 29:
+		return decodeDDSMasked(data, width, height, ddsPixelFormat{rgba, 32, [4]uint32{0xff, 0xff00, 0xff0000, 0xff000000}})
+	case 87, 90, 91:
+		return decodeDDSMasked(data, width, height, ddsPixelFormat{rgba, 32, [4]uint32{0xff0000, 0xff00, 0xff, 0xff000000}})
+	case 88, 92, 93:
+		return decodeDDSMasked(data, width, height, ddsPixelFormat{ddpfRGB, 32, [4]uint32{0xff0000, 0xff00, 0xff, 0}})
+	case 23, 24:
+		return decodeDDSMasked(data, width, height, ddsPixelFormat{rgba, 32, [4]uint32{0x3ff, 0xffc00, 0x3ff00000, 0xc0000000}})
+	case 85:
+		return decodeDDSMasked(data, width, height, ddsPixelFormat{ddpfRGB, 16, [4]uint32{0xf800, 0x7e0, 0x1f, 0}})
+	case 86:
+		return decodeDDSMasked(data, width, height, ddsPixelFormat{rgba, 16, [4]uint32{0x7c00, 0x3e0, 0x1f, 0x8000}})
+	case 115:
+		return decodeDDSMasked(data, width, height, ddsPixelFormat{rgba, 16, [4]uint32{0xf00, 0xf0, 0xf, 0xf000}})
+	case 48, 49:
+		return decodeDDSMasked(data, width, height, ddsPixelFormat{ddpfRGB, 16, [4]uint32{0xff, 0xff00, 0, 0}})
+	case 60, 61:
+		return decodeDDSMasked(data, width, height, ddsPixelFormat{ddpfRGB, 8, [4]uint32{0xff, 0, 0, 0}})
+	case 65:
+		return decodeDDSMasked(data, width, height, ddsPixelFormat{ddpfAlpha, 8, [4]uint32{0, 0, 0, 0xff}})
+	}
+	return nil, fmt.Errorf("unsupported DDS DXGI format %d", format)
+}
+
+// decodeDDSMasked decodes uncompressed pixels, channels are extracted with bit masks and scaled to 8 bits.
+func decodeDDSMasked(data []byte, width, height int, pf ddsPixelFormat) (image.Image, error) {
+	if pf.Flags&(ddpfRGB|ddpfLuminance|ddpfAlpha) == 0 {
+		return nil, fmt.Errorf("unsupported DDS pixel format flags 0x%x", pf.Flags)
+	}
+	if pf.BitCount != 8 && pf.BitCount != 16 && pf.BitCount != 24 && pf.BitCount != 32 {
+		return nil, fmt.Errorf("unsupported DDS bit count %d", pf.BitCount)
+	}
+	bpp := int(pf.BitCount / 8)
+	if len(data) < width*height*bpp {
+		return nil, fmt.Errorf("DDS image data is too short")
+	}
+	hasAlpha := pf.Flags&(ddpfAlphaPixels|ddpfAlpha) != 0 && pf.Masks[3] != 0
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := data[(y*width+x)*bpp:]
+			var v uint32
+			for i := bpp - 1; i >= 0; i-- {
+				v = v<<8 | uint32(p[i])
+			}
+
+			c := color.NRGBA{maskedValue(v, pf.Masks[0]), maskedValue(v, pf.Masks[1]), maskedValue(v, pf.Masks[2]), 255}
+			if pf.Flags&ddpfLuminance != 0 {
+				c.G, c.B = c.R, c.R
+			}
+			if hasAlpha {
+				c.A = maskedValue(v, pf.Masks[3])
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img, nil
+}
+
+// maskedValue returns channel value selected by mask, scaled to 0-255 range.
+func maskedValue(v, mask uint32) uint8 {
+	if mask == 0 {
+		return 0
+	}
+	max := uint64(1)<<uint(bits.OnesCount32(mask)) - 1
+	x := uint64((v & mask) >> uint(bits.TrailingZeros32(mask)))
+	return uint8((x*255 + max/2) / max)
+}
+
+// decodeDDSBlocks decodes block compressed image data, each block holds 4x4 pixels.
+func decodeDDSBlocks(data []byte, width, height, blockSize int, decodeBlock func(b []byte, px *[16]color.NRGBA)) (image.Image, error) {
+	bw, bh := (width+3)/4, (height+3)/4
+	if len(data) < bw*bh*blockSize {
+		return nil, fmt.Errorf("DDS image data is too short")
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	var px [16]color.NRGBA
+	for by := 0; by < bh; by++ {
+		for bx := 0; bx < bw; bx++ {
+			off := (by*bw + bx) * blockSize
+			decodeBlock(data[off:off+blockSize], &px)
+			for i, c := range px {
+				x, y := bx*4+i%4, by*4+i/4
+				if x < width && y < height {
+					img.SetNRGBA(x, y, c)
+				}
+			}
+		}
+	}
+	return img, nil
+}
+
+func decodeBC1(b []byte, px *[16]color.NRGBA) {
+	decodeColorBlock(b, px, true)
+}
+
+func decodeBC2(b []byte, px *[16]color.NRGBA) {
+	decodeColorBlock(b[8:], px, false)
+	alpha := binary.LittleEndian.Uint64(b)
+	for i := range px {
+		px[i].A = uint8(alpha>>(4*uint(i))&0xf) * 17
+	}
+}
+
+func decodeBC3(b []byte, px *[16]color.NRGBA) {
+	decodeColorBlock(b[8:], px, false)
+	alpha := decodeAlphaBlock(b, false)
+	for i := range px {
+		px[i].A = alpha[i]
+	}
+}
+
+// Single channel textures are shown in grey.
+func decodeBC4(b []byte, px *[16]color.NRGBA) {
+	for i, v := range decodeAlphaBlock(b, false) {
+		px[i] = color.NRGBA{v, v, v, 255}
+	}
+}
+
+func decodeBC4S(b []byte, px *[16]color.NRGBA) {
+	for i, v := range decodeAlphaBlock(b, true) {
+		px[i] = color.NRGBA{v, v, v, 255}
+	}
+}
+
+func decodeBC5(b []byte, px *[16]color.NRGBA) {
+	red, green := decodeAlphaBlock(b, false), decodeAlphaBlock(b[8:], false)
+	for i := range px {
+		px[i] = color.NRGBA{red[i], green[i], 0, 255}
+	}
+}
+
+func decodeBC5S(b []byte, px *[16]color.NRGBA) {
+	red, green := decodeAlphaBlock(b, true), decodeAlphaBlock(b[8:], true)
+	for i := range px {
+		px[i] = color.NRGBA{red[i], green[i], 0, 255}
+	}
+}
+
+// decodeColorBlock decodes BC1 color block.
+// Block with first color not greater then the second one has transparent black color if punchThrough is set.
+func decodeColorBlock(b []byte, px *[16]color.NRGBA, punchThrough bool) {
+	c0 := binary.LittleEndian.Uint16(b)
+	c1 := binary.LittleEndian.Uint16(b[2:])
+
+	var palette [4]color.NRGBA
+	palette[0], palette[1] = rgb565(c0), rgb565(c1)
+	if c0 > c1 || !punchThrough {
+		palette[2] = mixColors(palette[0], palette[1], 2, 1)
+		palette[3] = mixColors(palette[0], palette[1], 1, 2)
+	} else {
+		palette[2] = mixColors(palette[0], palette[1], 1, 1)
+	}
+
+	indices := binary.LittleEndian.Uint32(b[4:])
+	for i := range px {
+		px[i] = palette[indices>>(2*uint(i))&3]
+	}
+}
+
+func rgb565(c uint16) color.NRGBA {
+	r, g, b := uint8(c>>11), uint8(c>>5&0x3f), uint8(c&0x1f)
+	return color.NRGBA{r<<3 | r>>2, g<<2 | g>>4, b<<3 | b>>2, 255}
+}
+
+// mixColors returns weighted average of two opaque colors.
+func mixColors(c0, c1 color.NRGBA, w0, w1 int) color.NRGBA {
+	mix := func(a, b uint8) uint8 {
+		return uint8((int(a)*w0 + int(b)*w1) / (w0 + w1))
+	}
+	return color.NRGBA{mix(c0.R, c1.R), mix(c0.G, c1.G), mix(c0.B, c1.B), 255}
+}
+
+// decodeAlphaBlock decodes BC3 alpha block, which is also used for BC4 and BC5 channels.
+// Signed values are moved to 0-255 range.
+func decodeAlphaBlock(b []byte, signed bool) [16]uint8 {
+	e0, e1 := int(b[0]), int(b[1])
+	low, high := 0, 255
+	if signed {
+		e0, e1 = int(int8(b[0])), int(int8(b[1]))
+		if e0 < -127 {
+			e0 = -127
+		}
+		if e1 < -127 {
+			e1 = -127
+		}
+		low, high = -127, 127
+	}
+
+	var palette [8]int
+	palette[0], palette[1] = e0, e1
+	if e0 > e1 {
+		for i := 1; i < 7; i++ {
+			palette[i+1] = ((7-i)*e0 + i*e1) / 7
+		}
+	} else {
+		for i := 1; i < 5; i++ {
+			palette[i+1] = ((5-i)*e0 + i*e1) / 5
+		}
+		palette[6], palette[7] = low, high
+	}
+
+	var indices uint64
+	for i := 7; i >= 2; i-- {
+		indices = indices<<8 | uint64(b[i])
+	}
+
+	var values [16]uint8
+	for i := range values {
+		v := palette[indices>>(3*uint(i))&7]
+		if signed {
+			v = (v + 127) * 255 / 254
+		}
+		values[i] = uint8(v)
+	}
+	return values
+}
+
+type bc7Mode struct {
+	Subsets            int
+	PartitionBits      int
+	RotationBits       int
+	IndexSelectionBits int
+	ColorBits          int
+	AlphaBits          int
+	EndpointPBits      bool
+	SharedPBits        bool
+	IndexBits          int
+	IndexBits2         int
+}
+
+var bc7Modes = [8]bc7Mode{
+	{3, 4, 0, 0, 4, 0, true, false, 3, 0},
+	{2, 6, 0, 0, 6, 0, false, true, 3, 0},
+	{3, 6, 0, 0, 5, 0, false, false, 2, 0},
+	{2, 6, 0, 0, 7, 0, true, false, 2, 0},
+	{1, 0, 2, 1, 5, 6, false, false, 2, 3},
+	{1, 0, 2, 0, 7, 8, false, false, 2, 2},
+	{1, 0, 0, 0, 7, 7, true, false, 4, 0},
+	{2, 6, 0, 0, 5, 5, true, false, 2, 0},
+}
+
+// Two subset partitions, bit i is the subset of pixel i.
+var bc7Partitions2 = [64]uint16{
+	0xcccc, 0x8888, 0xeeee, 0xecc8, 0xc880, 0xfeec, 0xfec8, 0xec80,
+	0xc800, 0xffec, 0xfe80, 0xe800, 0xffe8, 0xff00, 0xfff0, 0xf000,
+	0xf710, 0x008e, 0x7100, 0x08ce, 0x008c, 0x7310, 0x3100, 0x8cce,
+	0x088c, 0x3110, 0x6666, 0x366c, 0x17e8, 0x0ff0, 0x718e, 0x399c,
+	0xaaaa, 0xf0f0, 0x5a5a, 0x33cc, 0x3c3c, 0x55aa, 0x9696, 0xa55a,
+	0x73ce, 0x13c8, 0x324c, 0x3bdc, 0x6996, 0xc33c, 0x9966, 0x0660,
+	0x0272, 0x04e4, 0x4e40, 0x2720, 0xc936, 0x936c, 0x39c6, 0x639c,
+	0x9336, 0x9cc6, 0x817e, 0xe718, 0xccf0, 0x0fcc, 0x7744, 0xee22,
+}
+
+// Three subset partitions, bits 2*i and 2*i+1 are the subset of pixel i.
+var bc7Partitions3 = [64]uint32{
+	0xaa685050, 0x6a5a5040, 0x5a5a4200, 0x5450a0a8, 0xa5a50000, 0xa0a05050, 0x5555a0a0, 0x5a5a5050,
+	0xaa550000, 0xaa555500, 0xaaaa5500, 0x90909090, 0x94949494, 0xa4a4a4a4, 0xa9a59450, 0x2a0a4250,
+	0xa5945040, 0x0a425054, 0xa5a5a500, 0x55a0a0a0, 0xa8a85454, 0x6a6a4040, 0xa4a45000, 0x1a1a0500,
+	0x0050a4a4, 0xaaa59090, 0x14696914, 0x69691400, 0xa08585a0, 0xaa821414, 0x50a4a450, 0x6a5a0200,
+	0xa9a58000, 0x5090a0a8, 0xa8a09050, 0x24242424, 0x00aa5500, 0x24924924, 0x24499224, 0x50a50a50,
+	0x500aa550, 0xaaaa4444, 0x66660000, 0xa5a0a5a0, 0x50a050a0, 0x69286928, 0x44aaaa44, 0x66666600,
+	0xaa444444, 0x54a854a8, 0x95809580, 0x96969600, 0xa85454a8, 0x80959580, 0xaa141414, 0x96960000,
+	0xaaaa1414, 0xa05050a0, 0xa0a5a5a0, 0x96000000, 0x40804080, 0xa9a8a9a8, 0xaaaaaa44, 0x2a4a5254,
+}
+
+// Anchor pixels of the second subset in two subset partitions.
+var bc7Anchors2 = [64]int{
+	15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15,
+	15, 2, 8, 2, 2, 8, 8, 15, 2, 8, 2, 2, 8, 8, 2, 2,
+	15, 15, 6, 8, 2, 8, 15, 15, 2, 8, 2, 2, 2, 15, 15, 6,
+	6, 2, 6, 8, 15, 15, 2, 2, 15, 15, 15, 15, 15, 2, 2, 15,
+}
+
+// Anchor pixels of the second and the third subsets in three subset partitions.
+var bc7Anchors3 = [2][64]int{
+	{
+		3, 3, 15, 15, 8, 3, 15, 15, 8, 8, 6, 6, 6, 5, 3, 3,
+		3, 3, 8, 15, 3, 3, 6, 10, 5, 8, 8, 6, 8, 5, 15, 15,
+		8, 15, 3, 5, 6, 10, 8, 15, 15, 3, 15, 5, 15, 15, 15, 15,
+		3, 15, 5, 5, 5, 8, 5, 10, 5, 10, 8, 13, 15, 12, 3, 3,
+	},
+	{
+		15, 8, 8, 3, 15, 15, 3, 8, 15, 15, 15, 15, 15, 15, 15, 8,
+		15, 8, 15, 3, 15, 8, 15, 8, 3, 15, 6, 10, 15, 15, 10, 8,
+		15, 3, 15, 10, 10, 8, 9, 10, 6, 15, 8, 15, 3, 6, 6, 8,
+		15, 3, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 3, 15, 15, 8,
+	},
+}
+
+var bc7Weights = map[int][]int{
+	2: {0, 21, 43, 64},
+	3: {0, 9, 18, 27, 37, 46, 55, 64},
+	4: {0, 4, 9, 13, 17, 21, 26, 30, 34, 38, 43, 47, 51, 55, 60, 64},
+}
+
+// bitReader reads little endian bit fields from the block.
+type bitReader struct {
+	b   []byte
+	pos uint
+}
+
+func (r *bitReader) read(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		v |= int(r.b[r.pos>>3]>>(r.pos&7)&1) << uint(i)
+		r.pos++
+	}
+	return v
+}
+
+func decodeBC7(b []byte, px *[16]color.NRGBA) {
+	mode := 0
+	for mode < 8 && b[0]&(1<<uint(mode)) == 0 {
+		mode++
+	}
+	if mode == 8 {
+		// Reserved mode, decoded as transparent black.
+		*px = [16]color.NRGBA{}
+		return
+	}
+	m := bc7Modes[mode]
+	r := bitReader{b: b, pos: uint(mode + 1)}
+
+	partition := r.read(m.PartitionBits)
+	rotation := r.read(m.RotationBits)
+	indexSelection := r.read(m.IndexSelectionBits)
+
+	// Endpoints are stored channel by channel.
+	var endpoints [6][4]int
+	n := m.Subsets * 2
+	for c := 0; c < 3; c++ {
+		for i := 0; i < n; i++ {
+			endpoints[i][c] = r.read(m.ColorBits)
+		}
+	}
+	for i := 0; i < n && m.AlphaBits > 0; i++ {
+		endpoints[i][3] = r.read(m.AlphaBits)
+	}
+
+	// P-bits are the lowest bits of every endpoint channel, shared ones are used by both endpoints of the subset.
+	colorBits, alphaBits := m.ColorBits, m.AlphaBits
+	if m.EndpointPBits || m.SharedPBits {
+		for i := 0; i < n; i++ {
+			if m.EndpointPBits || i%2 == 0 {
+				p := r.read(1)
+				addPBit(&endpoints[i], p)
+				if m.SharedPBits {
+					addPBit(&endpoints[i+1], p)
+				}
+			}
+		}
+		colorBits++
+		alphaBits++
+	}
+	for i := 0; i < n; i++ {
+		for c := 0; c < 3; c++ {
+			endpoints[i][c] = expandBits(endpoints[i][c], colorBits)
+		}
+		if m.AlphaBits > 0 {
+			endpoints[i][3] = expandBits(endpoints[i][3], alphaBits)
+		} else {
+			endpoints[i][3] = 255
+		}
+	}
+
+	var subsets, indices, indices2 [16]int
+	for i := range indices {
+		anchor := i == 0
+		switch m.Subsets {
+		case 2:
+			subsets[i] = int(bc7Partitions2[partition] >> uint(i) & 1)
+			anchor = anchor || i == bc7Anchors2[partition]
+		case 3:
+			subsets[i] = int(bc7Partitions3[partition] >> uint(2*i) & 3)
+			anchor = anchor || i == bc7Anchors3[0][partition] || i == bc7Anchors3[1][partition]
+		}
+		// Anchor indices have implicit zero high bit.
+		if anchor {
+			indices[i] = r.read(m.IndexBits - 1)
+		} else {
+			indices[i] = r.read(m.IndexBits)
+		}
+	}
+	for i := 0; i < 16 && m.IndexBits2 > 0; i++ {
+		if i == 0 {
+			indices2[i] = r.read(m.IndexBits2 - 1)
+		} else {
+			indices2[i] = r.read(m.IndexBits2)
+		}
+	}
+
+	for i := range px {
+		e0, e1 := endpoints[2*subsets[i]], endpoints[2*subsets[i]+1]
+		colorWeight := bc7Weights[m.IndexBits][indices[i]]
+		alphaWeight := colorWeight
+		if m.IndexBits2 > 0 {
+			alphaWeight = bc7Weights[m.IndexBits2][indices2[i]]
+			if indexSelection == 1 {
+				colorWeight = bc7Weights[m.IndexBits2][indices2[i]]
+				alphaWeight = bc7Weights[m.IndexBits][indices[i]]
+			}
+		}
+
+		var c [4]uint8
+		for j := range c {
+			w := colorWeight
+			if j == 3 {
+				w = alphaWeight
+			}
+			c[j] = uint8(((64-w)*e0[j] + w*e1[j] + 32) >> 6)
+		}
+		if rotation > 0 {
+			c[rotation-1], c[3] = c[3], c[rotation-1]
+		}
+		px[i] = color.NRGBA{c[0], c[1], c[2], c[3]}
+	}
+}
+
+func addPBit(endpoint *[4]int, p int) {
+	for c := range endpoint {
+		endpoint[c] = endpoint[c]<<1 | p
+	}
+}
+
+// expandBits scales n-bit value to 8 bits by replicating its high bits.
+func expandBits(v, n int) int {
+	v <<= uint(8 - n)
+	return v | v>>uint(n)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Fixtures in testdata hold a single 4x4 block, or a few pixels for uncompressed formats.
+func TestDecodeDDS(t *testing.T) {
+	tests := []struct {
+		file   string
+		width  int
+		height int
+		pixels []color.NRGBA
+	}{
+		// First color is not greater than the second one, so the last palette entry is transparent black.
+		{"dxt1_punch_through.dds", 4, 4, []color.NRGBA{
+			{0, 0, 255, 255}, {255, 0, 0, 255}, {127, 0, 127, 255}, {0, 0, 0, 0},
+			{0, 0, 255, 255}, {255, 0, 0, 255}, {127, 0, 127, 255}, {0, 0, 0, 0},
+			{0, 0, 255, 255}, {255, 0, 0, 255}, {127, 0, 127, 255}, {0, 0, 0, 0},
+			{0, 0, 255, 255}, {255, 0, 0, 255}, {127, 0, 127, 255}, {0, 0, 0, 0},
+		}},
+		// Four color block cropped to the 3x2 image.
+		{"dxt1.dds", 3, 2, []color.NRGBA{
+			{255, 0, 0, 255}, {0, 0, 255, 255}, {170, 0, 85, 255},
+			{255, 0, 0, 255}, {0, 0, 255, 255}, {170, 0, 85, 255},
+		}},
+		// Explicit 4 bit alpha.
+		{"dxt3.dds", 4, 4, []color.NRGBA{
+			{255, 255, 255, 0}, {255, 255, 255, 17}, {255, 255, 255, 34}, {255, 255, 255, 51},
+			{255, 255, 255, 68}, {255, 255, 255, 85}, {255, 255, 255, 102}, {255, 255, 255, 119},
+			{255, 255, 255, 136}, {255, 255, 255, 153}, {255, 255, 255, 170}, {255, 255, 255, 187},
+			{255, 255, 255, 204}, {255, 255, 255, 221}, {255, 255, 255, 238}, {255, 255, 255, 255},
+		}},
+		// Eight interpolated alpha values.
+		{"dxt5.dds", 4, 4, []color.NRGBA{
+			{255, 255, 255, 252}, {255, 255, 255, 0}, {255, 255, 255, 216}, {255, 255, 255, 180},
+			{255, 255, 255, 144}, {255, 255, 255, 108}, {255, 255, 255, 72}, {255, 255, 255, 36},
+			{255, 255, 255, 252}, {255, 255, 255, 0}, {255, 255, 255, 216}, {255, 255, 255, 180},
+			{255, 255, 255, 144}, {255, 255, 255, 108}, {255, 255, 255, 72}, {255, 255, 255, 36},
+		}},
+		// Six interpolated values with 0 and 255 extremes.
+		{"bc4u.dds", 4, 4, []color.NRGBA{
+			{0, 0, 0, 255}, {250, 250, 250, 255}, {50, 50, 50, 255}, {100, 100, 100, 255},
+			{150, 150, 150, 255}, {200, 200, 200, 255}, {0, 0, 0, 255}, {255, 255, 255, 255},
+			{0, 0, 0, 255}, {250, 250, 250, 255}, {50, 50, 50, 255}, {100, 100, 100, 255},
+			{150, 150, 150, 255}, {200, 200, 200, 255}, {0, 0, 0, 255}, {255, 255, 255, 255},
+		}},
+		// -128 endpoint is clamped to -127, SNORM range is moved to 0-255.
+		{"bc4s.dds", 4, 4, []color.NRGBA{
+			{0, 0, 0, 255}, {255, 255, 255, 255}, {0, 0, 0, 255}, {255, 255, 255, 255},
+			{0, 0, 0, 255}, {255, 255, 255, 255}, {0, 0, 0, 255}, {255, 255, 255, 255},
+			{0, 0, 0, 255}, {255, 255, 255, 255}, {0, 0, 0, 255}, {255, 255, 255, 255},
+			{0, 0, 0, 255}, {255, 255, 255, 255}, {0, 0, 0, 255}, {255, 255, 255, 255},
+		}},
+		{"bc5u.dds", 4, 4, []color.NRGBA{
+			{0, 252, 0, 255}, {250, 0, 0, 255}, {50, 216, 0, 255}, {100, 180, 0, 255},
+			{150, 144, 0, 255}, {200, 108, 0, 255}, {0, 72, 0, 255}, {255, 36, 0, 255},
+			{0, 252, 0, 255}, {250, 0, 0, 255}, {50, 216, 0, 255}, {100, 180, 0, 255},
+			{150, 144, 0, 255}, {200, 108, 0, 255}, {0, 72, 0, 255}, {255, 36, 0, 255},
+		}},
+		{"bc5s.dds", 4, 4, []color.NRGBA{
+			{0, 0, 0, 255}, {255, 255, 0, 255}, {0, 0, 0, 255}, {255, 255, 0, 255},
+			{0, 0, 0, 255}, {255, 255, 0, 255}, {0, 0, 0, 255}, {255, 255, 0, 255},
+			{0, 0, 0, 255}, {255, 255, 0, 255}, {0, 0, 0, 255}, {255, 255, 0, 255},
+			{0, 0, 0, 255}, {255, 255, 0, 255}, {0, 0, 0, 255}, {255, 255, 0, 255},
+		}},
+		{"bc5s_dx10.dds", 4, 4, []color.NRGBA{
+			{0, 0, 0, 255}, {255, 255, 0, 255}, {0, 0, 0, 255}, {255, 255, 0, 255},
+			{0, 0, 0, 255}, {255, 255, 0, 255}, {0, 0, 0, 255}, {255, 255, 0, 255},
+			{0, 0, 0, 255}, {255, 255, 0, 255}, {0, 0, 0, 255}, {255, 255, 0, 255},
+			{0, 0, 0, 255}, {255, 255, 0, 255}, {0, 0, 0, 255}, {255, 255, 0, 255},
+		}},
+		// Mode 4 colors use 2 bit indices and alpha uses 3 bit ones.
+		{"bc7_mode4.dds", 4, 4, []color.NRGBA{
+			{0, 255, 0, 0}, {84, 171, 0, 36}, {171, 84, 0, 72}, {255, 0, 0, 108},
+			{0, 255, 0, 183}, {84, 171, 0, 219}, {171, 84, 0, 255}, {255, 0, 0, 0},
+			{0, 255, 0, 72}, {84, 171, 0, 108}, {171, 84, 0, 147}, {255, 0, 0, 183},
+			{0, 255, 0, 255}, {84, 171, 0, 0}, {171, 84, 0, 36}, {255, 0, 0, 72},
+		}},
+		// The same indices with index selection bit set, colors use 3 bit indices and alpha uses 2 bit ones.
+		{"bc7_mode4_index_selection.dds", 4, 4, []color.NRGBA{
+			{0, 255, 0, 0}, {36, 219, 0, 84}, {72, 183, 0, 171}, {108, 147, 0, 255},
+			{183, 72, 0, 0}, {219, 36, 0, 84}, {255, 0, 0, 171}, {0, 255, 0, 255},
+			{72, 183, 0, 0}, {108, 147, 0, 84}, {147, 108, 0, 171}, {183, 72, 0, 255},
+			{255, 0, 0, 0}, {0, 255, 0, 84}, {36, 219, 0, 171}, {72, 183, 0, 255},
+		}},
+		{"bc7_mode5.dds", 4, 4, []color.NRGBA{
+			{0, 255, 129, 0}, {84, 171, 129, 0}, {171, 84, 129, 0}, {255, 0, 129, 0},
+			{0, 255, 129, 84}, {84, 171, 129, 84}, {171, 84, 129, 84}, {255, 0, 129, 84},
+			{0, 255, 129, 171}, {84, 171, 129, 171}, {171, 84, 129, 171}, {255, 0, 129, 171},
+			{0, 255, 129, 255}, {84, 171, 129, 255}, {171, 84, 129, 255}, {255, 0, 129, 255},
+		}},
+		// Red and alpha channels are swapped.
+		{"bc7_mode5_rotation.dds", 4, 4, []color.NRGBA{
+			{0, 255, 129, 0}, {0, 171, 129, 84}, {0, 84, 129, 171}, {0, 0, 129, 255},
+			{84, 255, 129, 0}, {84, 171, 129, 84}, {84, 84, 129, 171}, {84, 0, 129, 255},
+			{171, 255, 129, 0}, {171, 171, 129, 84}, {171, 84, 129, 171}, {171, 0, 129, 255},
+			{255, 255, 129, 0}, {255, 171, 129, 84}, {255, 84, 129, 171}, {255, 0, 129, 255},
+		}},
+		// Uncompressed pixels with channel masks.
+		{"a1r5g5b5.dds", 2, 1, []color.NRGBA{
+			{255, 0, 0, 255}, {0, 0, 255, 0},
+		}},
+		{"a2b10g10r10.dds", 2, 1, []color.NRGBA{
+			{255, 0, 0, 85}, {0, 255, 0, 255},
+		}},
+		{"l8.dds", 2, 1, []color.NRGBA{
+			{0, 0, 0, 255}, {200, 200, 200, 255},
+		}},
+		{"r8g8b8a8_dx10.dds", 2, 1, []color.NRGBA{
+			{10, 20, 30, 40}, {50, 60, 70, 80},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			img, err := decodeTexture(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := img.Bounds().Size(); size != (image.Point{tt.width, tt.height}) {
+				t.Fatalf("got size %v, want %vx%v", size, tt.width, tt.height)
+			}
+			for i, want := range tt.pixels {
+				x, y := i%tt.width, i/tt.width
+				got := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				if got != want {
+					t.Errorf("pixel %v, %v is %v, want %v", x, y, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeDDSErrors(t *testing.T) {
+	tests := []struct {
+		file string
+		err  string
+	}{
+		{"bc6h.dds", "BC6H HDR textures are not supported"},
+		{"unknown.dds", `unsupported DDS format "DXT9"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			_, err := decodeTexture(filepath.Join("testdata", tt.file))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"image/png"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,7 +17,6 @@ import (
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/widget"
 	"github.com/k0kubun/go-ansi"
-	"github.com/macroblock/imed/pkg/ptool"
 	browser "github.com/malashin/dialog"
 )
 
@@ -30,8 +31,16 @@ func setupUI(app fyne.App) {
 			widget.NewButton("Select HOI4 folder", func() { selectGameFolder() }),
 			widget.NewButton("Add dependency mod folder(s)", func() { selectModFolder() }),
 			widget.NewButton("Select localisation language", func() { selectLocLanguage(app) }),
+			widget.NewButton("Select owned DLCs", func() { selectOwnedDLCs(app) }),
+			widget.NewButton("Select search filters", func() { selectSearchFilters(app) }),
+			widget.NewButton("Select sprite frames", func() { selectSpriteFrames(app) }),
+			widget.NewButton("Select scripted localisation", func() { selectScriptedLoc(app) }),
 			widget.NewButton("Generate image", func() { start() }),
 			widget.NewCheck("Disable line rendering", func(on bool) { lineRenderingToggle(on) }),
+			newContinuousFocusSelect(),
+			widget.NewCheck("Lenient parsing (skip broken blocks)", func(on bool) { lenientParsingToggle(on) }),
+			widget.NewCheck("Show focus durations", func(on bool) { durationRenderingToggle(on) }),
+			newDaysPerCostEntry(),
 			pBar,
 			widget.NewButton("Quit", func() {
 				app.Quit()
@@ -92,11 +101,61 @@ func selectModFolder() {
 }
 
 func selectLocLanguage(app fyne.App) {
+	coverage, total, err := scanLocLanguages()
+	if err != nil {
+		showError(err)
+		return
+	}
+
+	// Languages of the game are listed even if they were not found.
+	for code := range languageNames {
+		if _, ok := coverage[code]; !ok {
+			coverage[code] = 0
+		}
+	}
+	var options []string
+	codes := make(map[string]string)
+	for code, n := range coverage {
+		option := code
+		if name, ok := languageNames[code]; ok {
+			option = name + " (" + code + ")"
+		}
+		if total > 0 {
+			option += " - " + strconv.Itoa(n) + "/" + strconv.Itoa(total) + " focuses"
+		}
+		options = append(options, option)
+		codes[option] = code
+	}
+	sort.Strings(options)
+
 	w := app.NewWindow("Select localisation language")
 
+	languageGroup := widget.NewRadioGroup(options, nil)
+	renderGroup := widget.NewCheckGroup(options, nil)
+	var rendered []string
+	for option, code := range codes {
+		if code == language {
+			languageGroup.SetSelected(option)
+		}
+		if containsString(renderLanguages, code) {
+			rendered = append(rendered, option)
+		}
+	}
+	renderGroup.SetSelected(rendered)
+
 	w.SetContent(
 		container.NewVBox(
-			widget.NewRadioGroup([]string{"English", "Brazilian Portuguese", "German", "French", "Spanish", "Polish", "Russian"}, func(s string) { handleLocLanguageChange(s, w) }),
+			languageGroup,
+			widget.NewLabel("Also render in these languages, images get a language suffix:"),
+			renderGroup,
+			widget.NewButton("Check all", func() { renderGroup.SetSelected(options) }),
+			widget.NewButton("Ok", func() {
+				var others []string
+				for _, option := range renderGroup.Selected {
+					others = append(others, codes[option])
+				}
+				handleLocLanguageChange(codes[languageGroup.Selected], others, w)
+			}),
 		),
 	)
 
@@ -104,24 +163,290 @@ func selectLocLanguage(app fyne.App) {
 	w.Show()
 }
 
-func handleLocLanguageChange(s string, w fyne.Window) {
-	switch s {
-	case "English":
-		language = "l_english"
-	case "Brazilian Portuguese":
-		language = "l_braz_por"
-	case "German":
-		language = "l_german"
-	case "French":
-		language = "l_french"
-	case "Spanish":
-		language = "l_spanish"
-	case "Polish":
-		language = "l_polish"
-	case "Russian":
-		language = "l_russian"
-	}
-	ansi.Println("Language selected:", s)
+// scanLocLanguages returns languages found in localisation headers and how many focus titles of selected focus trees each one has.
+// Focus trees are only read for their title keys, the game folder and mods are the ones selected so far.
+func scanLocLanguages() (coverage map[string]int, total int, err error) {
+	err = loadSavedGamePath()
+	if err != nil {
+		return nil, 0, err
+	}
+	var paths []string
+	for _, p := range append(append([]string{gamePath}, modPaths...), focusTreePaths...) {
+		if containsString(focusTreePaths, p) {
+			p = focusTreeModPath(p)
+		}
+		if p != "" && !containsString(paths, p) {
+			paths = append(paths, p)
+		}
+	}
+
+	var keys []string
+	if len(focusTreePaths) > 0 {
+		err = buildParsers()
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, p := range focusTreePaths {
+			titles, err := focusTitleKeys(p)
+			if err != nil {
+				return nil, 0, err
+			}
+			for _, key := range titles {
+				if !containsString(keys, key) {
+					keys = append(keys, key)
+				}
+			}
+		}
+	}
+
+	ansi.Println("\x1b[33;1m" + "Scanning localisation languages:" + "\x1b[0m")
+	coverage, err = locLanguages(paths, keys)
+	if err != nil {
+		return nil, 0, err
+	}
+	// Failing to save parse cache only makes the next scan slower.
+	err = saveParseCache()
+	if err != nil {
+		ansi.Println("\x1b[33m" + "Parse cache not saved: " + err.Error() + "\x1b[0m")
+	}
+	return coverage, len(keys), nil
+}
+
+// renderedLanguages returns the selected language followed by other languages trees are rendered in.
+func renderedLanguages() []string {
+	languages := []string{language}
+	for _, lang := range renderLanguages {
+		if !containsString(languages, lang) {
+			languages = append(languages, lang)
+		}
+	}
+	return languages
+}
+
+// focusTreeModPath returns the folder of the game or mod the focus tree file belongs to.
+func focusTreeModPath(focusTreePath string) string {
+	return filepath.Clean(strings.TrimSuffix(filepath.Dir(focusTreePath), filepath.Join("common", "national_focus")))
+}
+
+func handleLocLanguageChange(code string, others []string, w fyne.Window) {
+	if code != "" {
+		language = code
+	}
+	renderLanguages = others
+	sort.Strings(renderLanguages)
+	ansi.Println("Language selected:", language, renderLanguages)
+	w.Close()
+}
+
+func selectOwnedDLCs(app fyne.App) {
+	err := loadSavedGamePath()
+	if err != nil {
+		showError(err)
+		return
+	}
+	dlcList, err = findDLCs()
+	if err != nil {
+		showError(err)
+		return
+	}
+	// DLCs passed with --dlc are listed even if they were not found.
+	for dlc := range ownedDLCs {
+		if !containsString(dlcList, dlc) {
+			dlcList = append(dlcList, dlc)
+		}
+	}
+	sort.Strings(dlcList)
+
+	w := app.NewWindow("Select owned DLCs")
+
+	selected := dlcList
+	if ownedDLCs != nil {
+		selected = []string{}
+		for _, dlc := range dlcList {
+			if ownedDLCs[dlc] {
+				selected = append(selected, dlc)
+			}
+		}
+	}
+
+	dlcGroup := widget.NewCheckGroup(dlcList, nil)
+	dlcGroup.SetSelected(selected)
+
+	w.SetContent(
+		container.NewVBox(
+			dlcGroup,
+			widget.NewButton("Ok", func() { handleOwnedDLCsChange(dlcGroup.Selected, w) }),
+		),
+	)
+
+	w.CenterOnScreen()
+	w.Show()
+}
+
+func handleOwnedDLCsChange(selected []string, w fyne.Window) {
+	ownedDLCs = make(map[string]bool)
+	for _, dlc := range selected {
+		ownedDLCs[dlc] = true
+	}
+	ansi.Println("Owned DLCs selected:", selected)
+	err := saveOwnedDLCs()
+	if err != nil {
+		ansi.Println("\x1b[31;1m" + err.Error() + "\x1b[0m")
+		showError(err)
+		return
+	}
+	w.Close()
+}
+
+func selectSearchFilters(app fyne.App) {
+	w := app.NewWindow("Select search filters")
+
+	filterGroup := widget.NewCheckGroup(searchFilterList, nil)
+	otherFilters := widget.NewEntry()
+	otherFilters.SetPlaceHolder("Other filters, space separated")
+	for _, filter := range selectedSearchFilters {
+		if !containsString(searchFilterList, filter) {
+			otherFilters.SetText(strings.TrimSpace(otherFilters.Text + " " + filter))
+		}
+	}
+	filterGroup.SetSelected(selectedSearchFilters)
+
+	modes := []string{"Show all focuses", "Dim other focuses", "Hide other focuses"}
+	modeGroup := widget.NewRadioGroup(modes, nil)
+	switch searchFilterMode {
+	case SearchFilterDim:
+		modeGroup.SetSelected(modes[1])
+	case SearchFilterHide:
+		modeGroup.SetSelected(modes[2])
+	default:
+		modeGroup.SetSelected(modes[0])
+	}
+
+	iconsCheck := widget.NewCheck("Draw search filter icons", nil)
+	iconsCheck.SetChecked(isSearchFilterIconsOn)
+
+	w.SetContent(
+		container.NewVBox(
+			filterGroup,
+			otherFilters,
+			modeGroup,
+			iconsCheck,
+			widget.NewButton("Ok", func() {
+				filters := append(append([]string{}, filterGroup.Selected...), strings.Fields(otherFilters.Text)...)
+				handleSearchFiltersChange(filters, modeGroup.Selected, iconsCheck.Checked, w)
+			}),
+		),
+	)
+
+	w.CenterOnScreen()
+	w.Show()
+}
+
+func handleSearchFiltersChange(filters []string, mode string, icons bool, w fyne.Window) {
+	selectedSearchFilters = filters
+	switch mode {
+	case "Dim other focuses":
+		searchFilterMode = SearchFilterDim
+	case "Hide other focuses":
+		searchFilterMode = SearchFilterHide
+	default:
+		searchFilterMode = SearchFilterOff
+	}
+	isSearchFilterIconsOn = icons
+	ansi.Println("Search filters selected:", filters, mode)
+	w.Close()
+}
+
+func selectSpriteFrames(app fyne.App) {
+	w := app.NewWindow("Select sprite frames")
+
+	bgFrames := []string{"Background frame from the gui file", "1", "2", "3", "4"}
+	bgSelect := widget.NewSelect(bgFrames, nil)
+	bgSelect.SetSelected(bgFrames[0])
+	if bgStateFrame > 0 {
+		bgSelect.SetSelected(strconv.Itoa(bgStateFrame))
+	}
+
+	var overrides []string
+	for id, frame := range focusFrameOverrides {
+		overrides = append(overrides, id+"="+strconv.Itoa(frame))
+	}
+	sort.Strings(overrides)
+	overridesEntry := widget.NewMultiLineEntry()
+	overridesEntry.SetPlaceHolder("Focus icon frames, one FOCUS_ID=frame per line")
+	overridesEntry.SetText(strings.Join(overrides, "\n"))
+
+	w.SetContent(
+		container.NewVBox(
+			bgSelect,
+			overridesEntry,
+			widget.NewButton("Ok", func() { handleSpriteFramesChange(bgSelect.Selected, overridesEntry.Text, w) }),
+		),
+	)
+
+	w.CenterOnScreen()
+	w.Show()
+}
+
+func handleSpriteFramesChange(bgFrame, overrides string, w fyne.Window) {
+	bgStateFrame, _ = strconv.Atoi(bgFrame)
+
+	focusFrameOverrides = make(map[string]int)
+	for _, line := range strings.Fields(overrides) {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			showError(errors.New("Wrong focus icon frame \"" + line + "\", FOCUS_ID=frame expected"))
+			return
+		}
+		frame, err := strconv.Atoi(kv[1])
+		if err != nil || frame < 1 {
+			showError(errors.New("Wrong focus icon frame \"" + line + "\", frame must be a number higher then 0"))
+			return
+		}
+		focusFrameOverrides[kv[0]] = frame
+	}
+	ansi.Println("Sprite frames selected:", bgFrame, focusFrameOverrides)
+	w.Close()
+}
+
+func selectScriptedLoc(app fyne.App) {
+	w := app.NewWindow("Select scripted localisation")
+
+	var choices []string
+	for name, key := range scriptedLocChoices {
+		choices = append(choices, name+"="+key)
+	}
+	sort.Strings(choices)
+	choicesEntry := widget.NewMultiLineEntry()
+	choicesEntry.SetPlaceHolder("Scripted localisation texts, one GetName=LOC_KEY per line")
+	choicesEntry.SetText(strings.Join(choices, "\n"))
+
+	w.SetContent(
+		container.NewVBox(
+			widget.NewLabel("Texts are picked by their triggers otherwise, unknown triggers are skipped."),
+			choicesEntry,
+			widget.NewButton("Ok", func() { handleScriptedLocChange(choicesEntry.Text, w) }),
+		),
+	)
+
+	w.CenterOnScreen()
+	w.Show()
+}
+
+func handleScriptedLocChange(choices string, w fyne.Window) {
+	parsed, err := parseScriptedLocChoices(choices)
+	if err != nil {
+		showError(err)
+		return
+	}
+	scriptedLocChoices = parsed
+	ansi.Println("Scripted localisation selected:", scriptedLocChoices)
+	err = saveScriptedLocChoices()
+	if err != nil {
+		ansi.Println("\x1b[31;1m" + err.Error() + "\x1b[0m")
+		showError(err)
+		return
+	}
 	w.Close()
 }
 
@@ -133,44 +458,87 @@ func lineRenderingToggle(on bool) {
 	}
 }
 
+func lenientParsingToggle(on bool) {
+	isLenientParsing = on
+}
+
+func newContinuousFocusSelect() *widget.Select {
+	options := []string{"No continuous focuses", "Continuous focuses at tree position", "Continuous focuses in side panel"}
+	sel := widget.NewSelect(options, func(s string) {
+		switch s {
+		case "Continuous focuses at tree position":
+			continuousFocusMode = ContinuousFocusTree
+		case "Continuous focuses in side panel":
+			continuousFocusMode = ContinuousFocusSide
+		default:
+			continuousFocusMode = ContinuousFocusOff
+		}
+	})
+	sel.SetSelected(options[0])
+	return sel
+}
+
+func durationRenderingToggle(on bool) {
+	isDurationRenderingOn = on
+}
+
+func newDaysPerCostEntry() *widget.Entry {
+	entry := widget.NewEntry()
+	entry.SetPlaceHolder("Days per focus cost (" + strconv.FormatFloat(defaultDaysPerCost, 'f', -1, 64) + ")")
+	entry.Validator = func(s string) error {
+		_, err := parseDaysPerCost(s)
+		return err
+	}
+	entry.OnChanged = func(s string) {
+		var n float64
+		n, daysPerCostErr = parseDaysPerCost(s)
+		if daysPerCostErr == nil {
+			daysPerCost = n
+		}
+	}
+	return entry
+}
+
 func start() {
 	if running {
 		return
 	}
 	running = true
 
+	// Textures might have been changed since the last run.
+	textureCache.reset()
+	frameCache.reset()
+
 	var err error
 	locMap[language] = make(map[string]Localisation)
 	gfxList = append(gfxList, "GFX_focus_can_start")
+	if explainKey != "" {
+		locList = append(locList, explainKey)
+	}
 
-	switch {
-	case len(focusTreePaths) == 0:
+	if len(focusTreePaths) == 0 {
 		showError(errors.New("Focus file not selected"))
 		return
-	case gamePath == "":
-		p := filepath.Join(binPath, "hoi4treesnapGamePath.txt")
-		if _, err = os.Stat(p); err == nil {
-			err = decodeCacheFile(&gamePath, p)
-			if err != nil {
-				showError(err)
-				return
-			}
-		} else {
-			showError(errors.New("Game path not selected"))
-			return
-		}
+	}
+	if daysPerCostErr != nil {
+		showError(daysPerCostErr)
+		return
+	}
+	err = loadSavedGamePath()
+	if err != nil {
+		showError(err)
+		return
+	}
+	if gamePath == "" {
+		showError(errors.New("Game path not selected"))
+		return
 	}
 
 	// Track start time for benchmarking.
 	startTime := time.Now()
 
 	// Build parsers.
-	pdx, err = ptool.NewBuilder().FromString(pdxRule).Entries("entry").Build()
-	if err != nil {
-		showError(err)
-		return
-	}
-	yml, err = ptool.NewBuilder().FromString(ymlRule).Entries("entry").Build()
+	err = buildParsers()
 	if err != nil {
 		showError(err)
 		return
@@ -183,7 +551,7 @@ func start() {
 		focusTreeName := filepath.Base(focusTreePath)
 		focusTreeName = focusTreeName[0 : len(focusTreeName)-len(filepath.Ext(focusTreeName))]
 
-		modPath := filepath.Clean(strings.TrimSuffix(filepath.Dir(focusTreePath), filepath.Join("common", "national_focus")))
+		modPath := focusTreeModPath(focusTreePath)
 		// Add gamePath to the front of modsPath slice.
 		if !containsString(modPaths, gamePath) {
 			modPaths = append([]string{gamePath}, modPaths...)
@@ -202,39 +570,94 @@ func start() {
 		}
 		pBar.SetValue(0.05)
 
-		// Parse focus tree gui.
-		// Find the last nationalfocusview.gui in the modPaths slice.
-		guiPath := gamePath
-		if len(modPaths) > 1 {
-			for _, p := range modPaths[1:] {
-				if _, err = os.Stat(filepath.Join(p, "interface", "nationalfocusview.gui")); err == nil {
-					guiPath = p
+		// Parse inlay windows used by the focus tree.
+		guiNames := append([]string{}, focusGUIElementNames...)
+		if len(focusTree.InlayWindows) > 0 {
+			for _, p := range modPaths {
+				err = parseInlayWindows(p)
+				if err != nil {
+					showError(err)
+					return
+				}
+			}
+			for _, ref := range focusTree.InlayWindows {
+				if inlay, ok := inlayWindowMap[ref.ID]; ok {
+					guiNames = append(guiNames, inlay.WindowName)
 				}
 			}
 		}
-		err = parseGUI(guiPath)
+
+		// Parse focus tree gui.
+		err = parseGUI(guiNames)
 		if err != nil {
 			showError(err)
 			return
 		}
 		pBar.SetValue(0.1)
 
-		// GFX parsing.
-		for _, p := range modPaths {
-			err = parseGFX(p, len(modPaths))
+		// Parse continuous focus palettes.
+		if continuousFocusMode != ContinuousFocusOff {
+			for _, p := range modPaths {
+				err = parseContinuousFocus(p)
+				if err != nil {
+					showError(err)
+					return
+				}
+			}
+		}
+
+		// Parse search filter definitions for the filter icons.
+		if isSearchFilterIconsOn {
+			for _, p := range modPaths {
+				err = parseSearchFilters(p)
+				if err != nil {
+					showError(err)
+					return
+				}
+			}
+		}
+
+		// Parse localisation files of every rendered language.
+		languages := renderedLanguages()
+		for _, lang := range languages {
+			language = lang
+			err = parseLoc()
 			if err != nil {
+				language = languages[0]
 				showError(err)
 				return
 			}
-		}
-
-		// Parse localisation files.
-		for _, p := range modPaths {
-			err = parseLoc(p, len(modPaths))
+			err = parseReferencedLoc()
 			if err != nil {
+				language = languages[0]
 				showError(err)
 				return
 			}
+			printExplainedKey()
+
+			// Localisation is parsed first, so sprites of its inline icons are parsed too.
+			for _, l := range locMap[language] {
+				for _, name := range inlineIconNames(l.Value) {
+					gfxList = append(gfxList, "\""+name+"\"")
+				}
+			}
+		}
+		language = languages[0]
+
+		// GFX parsing.
+		err = parseGFX()
+		if err != nil {
+			showError(err)
+			return
+		}
+		if isSearchFilterIconsOn {
+			printUnresolvedSearchFilters()
+		}
+
+		// Failing to save parse cache only makes the next run slower.
+		err = saveParseCache()
+		if err != nil {
+			ansi.Println("\x1b[33m" + "Parse cache not saved: " + err.Error() + "\x1b[0m")
 		}
 
 		ansi.Println("\x1b[33;1m" + "Generating images:" + "\x1b[0m")
@@ -244,7 +667,11 @@ func start() {
 		pBar.SetValue(pBar.Value + 0.1/i)
 
 		// Calculate coordinates of focuses with relative positions.
-		fillAbsoluteFocusPositions(true)
+		err = fillAbsoluteFocusPositions()
+		if err != nil {
+			showError(err)
+			return
+		}
 		pBar.SetValue(pBar.Value + 0.1/i)
 
 		// Fill in focus structs with children data.
@@ -254,97 +681,202 @@ func start() {
 		// Move coordinates of focuses so that negative values are no longer present.
 		moveAbsoluteFocusPositionsToPositiveValues()
 
-		// Create image.
-		x, y := maxFocusPos(focusMap)
-		w := (x+2)*gui.FocusSpacing.X + spacingX + 17
-		h := (y+1)*gui.FocusSpacing.Y + spacingY
-
-		img := image.NewRGBA(image.Rectangle{image.ZP, image.Point{w, h}})
-		draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{0, 0, 0, 0}}, image.ZP, draw.Src)
+		// Fonts are loaded for every language when it is rendered.
+		replaceFontPathsIfNotFound()
 		pBar.SetValue(pBar.Value + 0.1/i)
 
-		// Init fonts.
-		replaceFontPathsIfNotFound()
-		font, err = initFont(gui.Name.Font)
+		err = renderInLanguages(focusTreeName, languages, func(outPath string) error {
+			return renderFocusTreeImage(outPath, 0.1/i/float64(len(languages)))
+		})
 		if err != nil {
 			showError(err)
 			return
 		}
+		printPathDurations()
+		pBar.SetValue(1)
+
+		// Clear maps.
+		focusMap = make(map[string]Focus)
+		focusTree = FocusTree{}
+		continuousFocusPaletteMap = make(map[string]ContinuousFocusPalette)
+		inlayWindowMap = make(map[string]InlayWindow)
+		searchFilterDefs = make(map[string]bool)
+		gfxMap = make(map[string]SpriteType)
+		fontMap = make(map[string]BitmapFont)
+		textFonts = make(map[string]*textFont)
+		textColors = make(map[string]color.NRGBA)
+		locMap = make(map[string]map[string]Localisation)
+		locWarnings = make(map[string]bool)
+		guiWarnings = make(map[string]bool)
+		scriptedLocMap = nil
+
+		// Hide progress bar.
+		pBar.Hide()
+		pBar.SetValue(0)
+	}
+
+	// Print out cache statistics and elapsed time.
+	ansi.Println("\x1b[30;1m" + "Texture cache: " + textureCache.String() + "\x1b[0m")
+	ansi.Println("\x1b[30;1m" + "Frame cache: " + frameCache.String() + "\x1b[0m")
+	elapsedTime := time.Since(startTime)
+	ansi.Printf("\x1b[30;1m"+"Elapsed time: %s\n\n"+"\x1b[0m", elapsedTime)
+	running = false
+}
+
+// renderFocusTreeImage draws parsed focus tree in the current language and saves it as PNG.
+// Errors of single focuses are printed, the last one is returned.
+// renderInLanguages renders the focus tree called name in every language with its fonts.
+// Language suffix is added to the image name only if there are several languages.
+func renderInLanguages(name string, languages []string, render func(outPath string) error) error {
+	defer func() { language = languages[0] }()
+	for _, lang := range languages {
+		language = lang
+		outPath := filepath.Join(binPath, name+".png")
+		if len(languages) > 1 {
+			ansi.Println("\x1b[33;1m" + "Rendering " + lang + ":" + "\x1b[0m")
+			outPath = filepath.Join(binPath, name+"_"+lang+".png")
+		}
+
+		var err error
+		font, err = initFont(gui.Name.Font)
+		if err != nil {
+			return err
+		}
 		fontTreeTitle, err = initFont(gui.NationalFocusTitle.Font)
 		if err != nil {
-			showError(err)
-			return
+			return err
 		}
-		pBar.SetValue(pBar.Value + 0.1/i)
-
-		if !isLineRenderingOff {
-			// Draw focus tree lines.
-			renderLines(img)
-			pBar.SetValue(pBar.Value + 0.1/i)
 
-			// Draw exclusivity lines.
-			err = renderExclusiveLines(img)
-			if err != nil {
-				showError(err)
-				return
-			}
+		err = render(outPath)
+		if err != nil {
+			return err
 		}
-		pBar.SetValue(pBar.Value + 0.1/i)
+	}
+	return nil
+}
 
-		// Draw focus icons.
-		var focusErrMap = make(map[string]bool)
-		for _, f := range focusMap {
-			err = renderFocus(img, f.X*gui.FocusSpacing.X+spacingX, f.Y*gui.FocusSpacing.Y+spacingY, f.ID)
-			// Save all focus icons errors into a map.
-			if err != nil {
-				focusErrMap[err.Error()] = true
-			}
+func renderFocusTreeImage(outPath string, step float64) error {
+	// Create image.
+	x, y := maxFocusPos(focusMap)
+	w := (x+2)*gui.FocusSpacing.X + spacingX + 17
+	h := (y+1)*gui.FocusSpacing.Y + spacingY
+
+	// Make room for continuous focus panel.
+	palette, isPaletteFound := activeContinuousFocusPalette()
+	var palettePos image.Point
+	if continuousFocusMode != ContinuousFocusOff && isPaletteFound {
+		size, err := continuousFocusPanelSize(palette)
+		if err != nil {
+			return err
 		}
-
-		// Print out all of the errors at once, popup window on the last one.
-		focusErrMapI := 0
-		for errString := range focusErrMap {
-			if focusErrMapI == len(focusErrMap)-1 {
-				showError(errors.New(errString))
-				return
-			}
-			ansi.Println("\x1b[31;1m" + errString + "\x1b[0m")
-			focusErrMapI++
+		palettePos = image.Point{w, spacingY}
+		if continuousFocusMode == ContinuousFocusTree && focusTree.HasContinuousFocusPosition {
+			palettePos = focusTreePoint(focusTree.ContinuousFocusPosition)
 		}
-		pBar.SetValue(pBar.Value + 0.1/i)
+		if palettePos.X+size.X > w {
+			w = palettePos.X + size.X
+		}
+		if palettePos.Y+size.Y > h {
+			h = palettePos.Y + size.Y
+		}
+	}
+
+	// Make room for path durations under the tree.
+	pathLines, _ := pathDurations()
+	pathY := h
+	if font != nil {
+		h += len(pathLines) * font.LineHeight
+	}
+
+	// Make room for inlay windows.
+	inlayBounds, err := inlayWindowBounds()
+	if err != nil {
+		return err
+	}
+	if inlayBounds.Max.X > w {
+		w = inlayBounds.Max.X
+	}
+	if inlayBounds.Max.Y > h {
+		h = inlayBounds.Max.Y
+	}
 
-		// Save image as PNG.
-		out, err := os.Create(filepath.Join(binPath, focusTreeName+".png"))
+	img := image.NewRGBA(image.Rectangle{image.ZP, image.Point{w, h}})
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{0, 0, 0, 0}}, image.ZP, draw.Src)
+	pBar.SetValue(pBar.Value + step)
+
+	if !isLineRenderingOff {
+		// Draw focus tree lines.
+		renderLines(img)
+		pBar.SetValue(pBar.Value + step)
+
+		// Draw exclusivity lines.
+		err = renderExclusiveLines(img)
 		if err != nil {
-			showError(err)
-			return
+			return err
 		}
-		err = png.Encode(out, img)
+	}
+	pBar.SetValue(pBar.Value + step)
+
+	// Draw focus icons.
+	var focusErrMap = make(map[string]bool)
+	for _, f := range focusMap {
+		err = renderFocus(img, f.X*gui.FocusSpacing.X+spacingX, f.Y*gui.FocusSpacing.Y+spacingY, f.ID)
+		// Save all focus icons errors into a map.
 		if err != nil {
-			showError(err)
-			return
+			focusErrMap[err.Error()] = true
 		}
-		err = out.Close()
+	}
+
+	// Draw inlay windows.
+	err = renderInlayWindows(img)
+	if err != nil {
+		focusErrMap[err.Error()] = true
+	}
+
+	// Draw continuous focuses.
+	if continuousFocusMode != ContinuousFocusOff && isPaletteFound {
+		err = renderContinuousFocuses(img, palettePos.X, palettePos.Y, palette)
 		if err != nil {
-			showError(err)
-			return
+			focusErrMap[err.Error()] = true
 		}
-		ansi.Println("Image saved at \"" + filepath.Join(binPath, focusTreeName+".png") + "\"")
-		pBar.SetValue(1)
+	}
 
-		// Clear maps.
-		focusMap = make(map[string]Focus)
-		gfxMap = make(map[string]SpriteType)
-		fontMap = make(map[string]BitmapFont)
-		locMap = make(map[string]map[string]Localisation)
+	// Draw path durations.
+	if font != nil {
+		for i, line := range pathLines {
+			font.RenderTextBox(img, spacingX, pathY+i*font.LineHeight, w-spacingX, font.LineHeight, false, false, line)
+		}
+	}
 
-		// Hide progress bar.
-		pBar.Hide()
-		pBar.SetValue(0)
+	printLocWarnings()
+	locWarnings = make(map[string]bool)
+	printGUIWarnings()
+	guiWarnings = make(map[string]bool)
+
+	// Print out all of the errors at once, popup window on the last one.
+	focusErrMapI := 0
+	for errString := range focusErrMap {
+		if focusErrMapI == len(focusErrMap)-1 {
+			return errors.New(errString)
+		}
+		ansi.Println("\x1b[31;1m" + errString + "\x1b[0m")
+		focusErrMapI++
 	}
+	pBar.SetValue(pBar.Value + step)
 
-	// Print out elapsed time.
-	elapsedTime := time.Since(startTime)
-	ansi.Printf("\x1b[30;1m"+"Elapsed time: %s\n\n"+"\x1b[0m", elapsedTime)
-	running = false
+	// Save image as PNG.
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(out, img)
+	if err != nil {
+		return err
+	}
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+	ansi.Println("Image saved at \"" + outPath + "\"")
+	return nil
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRenderInLanguages(t *testing.T) {
+	// Russian override is a copy of the same font, so it is loaded as another font.
+	dir := t.TempDir()
+	for _, ext := range []string{".fnt", ".dds"} {
+		b, err := ioutil.ReadFile("testdata/font" + ext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, "font"+ext), b, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	binPath = t.TempDir()
+	fontMap = map[string]BitmapFont{
+		"test":           {Name: "test", Fontfiles: []string{"testdata/font"}},
+		"test@l_russian": {Name: "test", Fontfiles: []string{filepath.Join(dir, "font")}, Languages: []string{"l_russian"}},
+	}
+	gui.Name.Font = "test"
+	gui.NationalFocusTitle.Font = "test"
+	locMap = map[string]map[string]Localisation{
+		"l_english": {"A": {Key: "A", Value: "English"}},
+		"l_russian": {"A": {Key: "A", Value: "Russian"}},
+		"l_german":  {},
+	}
+	defer func() {
+		language = "l_english"
+		fontMap = make(map[string]BitmapFont)
+		textFonts = make(map[string]*textFont)
+		locMap = make(map[string]map[string]Localisation)
+		gui = FocusGUI{}
+		font, fontTreeTitle = nil, nil
+	}()
+
+	type rendered struct {
+		name  string
+		value string
+		font  *textFont
+	}
+	render := func(languages []string) []rendered {
+		var got []rendered
+		err := renderInLanguages("tree", languages, func(outPath string) error {
+			if fontTreeTitle != font {
+				t.Errorf("%v: title font differs from the focus font", language)
+			}
+			value, _ := locValue("A")
+			got = append(got, rendered{filepath.Base(outPath), value, font})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if language != languages[0] {
+			t.Errorf("language is %v after rendering, want %v", language, languages[0])
+		}
+		return got
+	}
+
+	got := render([]string{"l_english"})
+	if len(got) != 1 || got[0].name != "tree.png" || got[0].value != "English" {
+		t.Errorf("got %+v", got)
+	}
+
+	got = render([]string{"l_russian", "l_english", "l_german"})
+	var names, values []string
+	for _, r := range got {
+		names = append(names, r.name)
+		values = append(values, r.value)
+	}
+	if want := []string{"tree_l_russian.png", "tree_l_english.png", "tree_l_german.png"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got images %q, want %q", names, want)
+	}
+	if want := []string{"Russian", "English", ""}; !reflect.DeepEqual(values, want) {
+		t.Errorf("got values %q, want %q", values, want)
+	}
+	if got[0].font == got[1].font || got[1].font != got[2].font || got[1].font != textFonts["test"] {
+		t.Error("russian font override is not used only for russian")
+	}
+}
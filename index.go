@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// nameIndex maps names defined in the files of a folder to those files.
+// Indexes are kept for the whole session, files are scanned again only if their size or modification time changes.
+type nameIndex struct {
+	Files map[string]indexedFile
+}
+
+type indexedFile struct {
+	Size     int64
+	ModTime  time.Time
+	Names    []string
+	Language string
+}
+
+// Quoted names may contain spaces.
+var gfxNameRegexp = regexp.MustCompile(`(?i)(?:^|[\s{])name\s*=\s*(?:"([^"]+)"|([^\s{}]+))`)
+var textColorsRegexp = regexp.MustCompile(`(?i)(?:^|[\s{])textcolors\s*=`)
+
+// textColorsName is indexed for gfx files that define § color codes.
+const textColorsName = "textcolors"
+
+var locKeyRegexp = regexp.MustCompile(`(?m)^\s*([^\s:#"]+):\d*\s*"`)
+
+// gfxNames returns sprite and font names defined in the gfx file.
+// Files with textcolors get textColorsName.
+func gfxNames(f string) ([]string, string) {
+	var names []string
+	for _, line := range strings.Split(f, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		for _, m := range gfxNameRegexp.FindAllStringSubmatch(line, -1) {
+			names = append(names, m[1]+m[2])
+		}
+		if textColorsRegexp.MatchString(line) {
+			names = append(names, textColorsName)
+		}
+	}
+	return names, ""
+}
+
+// locKeys returns keys defined in the localisation file and its language.
+func locKeys(f string) ([]string, string) {
+	var keys []string
+	for _, m := range locKeyRegexp.FindAllStringSubmatch(f, -1) {
+		keys = append(keys, m[1])
+	}
+	return keys, locFileLanguage(strings.TrimPrefix(f, "\ufeff"))
+}
+
+// locKeyLines returns line numbers of the keys defined in the localisation file.
+func locKeyLines(f string) map[string][]int {
+	lines := make(map[string][]int)
+	line, offset := 1, 0
+	for _, m := range locKeyRegexp.FindAllStringSubmatchIndex(f, -1) {
+		line += strings.Count(f[offset:m[2]], "\n")
+		offset = m[2]
+		key := f[m[2]:m[3]]
+		lines[key] = append(lines[key], line)
+	}
+	return lines
+}
+
+// indexedFiles returns files in the dir with ext extension that define any of the names, in load order.
+func indexedFiles(indexes map[string]*nameIndex, dir, ext string, scan func(string) ([]string, string), names []string) ([]string, error) {
+	files, err := WalkMatchExt(dir, ext)
+	if err != nil {
+		return nil, err
+	}
+
+	index, ok := indexes[dir]
+	if !ok {
+		index = &nameIndex{Files: make(map[string]indexedFile)}
+		indexes[dir] = index
+	}
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		// Sprite names are quoted in gfxList to match them as whole words.
+		wanted[trimQuotes(name)] = true
+	}
+
+	// Files that were removed since the last scan are dropped from the index.
+	scanned := make(map[string]indexedFile, len(files))
+	var matched []string
+	for _, path := range files {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		entry, ok := index.Files[path]
+		if !ok || entry.Size != fi.Size() || !entry.ModTime.Equal(fi.ModTime()) {
+			f, err := readFile(path)
+			if err != nil {
+				return nil, err
+			}
+			names, language := scan(f)
+			entry = indexedFile{fi.Size(), fi.ModTime(), names, language}
+			isParseCacheChanged = true
+		}
+		scanned[path] = entry
+
+		for _, name := range entry.Names {
+			if wanted[name] {
+				matched = append(matched, path)
+				break
+			}
+		}
+	}
+	if len(scanned) != len(index.Files) {
+		isParseCacheChanged = true
+	}
+	index.Files = scanned
+	return matched, nil
+}
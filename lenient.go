@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/k0kubun/go-ansi"
+	"github.com/macroblock/imed/pkg/ptool"
+)
+
+// parsePDX parses PDX script file contents.
+// In lenient mode top level blocks that can't be parsed are skipped and reported as warnings.
+func parsePDX(parser *ptool.TParser, f, path string) (*ptool.TNode, error) {
+	node, err := parser.Parse(f)
+	if err == nil || !isLenientParsing {
+		return node, err
+	}
+
+	b := []byte(f)
+	printParseWarnings(repairPDX(parser, b, path))
+	if len(bytes.TrimSpace(b)) == 0 {
+		return &ptool.TNode{}, nil
+	}
+	return parser.Parse(string(b))
+}
+
+// repairPDX blanks out top level blocks of b that can't be parsed and returns warnings about them.
+// Top level blocks are statements of the file and entries of its blocks, like focuses of focus_tree,
+// so parsing recovers at the next one and the rest of the file is kept.
+func repairPDX(parser *ptool.TParser, b []byte, path string) []string {
+	var warnings []string
+	for _, c := range splitPDXStatements(b, 0, len(b)) {
+		_, err := parser.Parse(string(b[c[0]:c[1]]))
+		if err == nil {
+			continue
+		}
+
+		if bodyStart, bodyEnd, ok := blockBody(b, c[0], c[1]); ok {
+			for _, e := range splitPDXStatements(b, bodyStart, bodyEnd) {
+				_, err := parser.Parse(string(b[e[0]:e[1]]))
+				if err != nil {
+					warnings = append(warnings, parseWarning(path, b, e[0], e[1], err))
+					blank(b, e[0], e[1])
+				}
+			}
+			_, err = parser.Parse(string(b[c[0]:c[1]]))
+			if err == nil {
+				continue
+			}
+		}
+
+		warnings = append(warnings, parseWarning(path, b, c[0], c[1], err))
+		blank(b, c[0], c[1])
+	}
+	return warnings
+}
+
+// splitPDXStatements returns start and end offsets of top level statements in b[start:end].
+// Unmatched closing braces are returned as separate statements.
+func splitPDXStatements(b []byte, start, end int) [][2]int {
+	var statements [][2]int
+	depth := 0
+	stmtStart := -1
+	inQuotes := false
+
+	for i := start; i < end; i++ {
+		c := b[i]
+		switch {
+		case inQuotes:
+			if c == '"' || c == '\n' {
+				inQuotes = false
+			}
+			continue
+		case c == '#':
+			for i < end && b[i] != '\n' {
+				i++
+			}
+			i--
+			continue
+		case c == '\n':
+			// Statements without blocks end with the line, unless value is on the next one.
+			if depth == 0 && stmtStart >= 0 && !bytes.HasSuffix(bytes.TrimSpace(b[stmtStart:i]), []byte("=")) {
+				statements = append(statements, [2]int{stmtStart, i})
+				stmtStart = -1
+			}
+			continue
+		case c <= ' ':
+			continue
+		}
+
+		switch c {
+		case '"':
+			inQuotes = true
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				if stmtStart >= 0 {
+					statements = append(statements, [2]int{stmtStart, i})
+					stmtStart = -1
+				}
+				statements = append(statements, [2]int{i, i + 1})
+				continue
+			}
+			depth--
+			if depth == 0 && stmtStart >= 0 {
+				statements = append(statements, [2]int{stmtStart, i + 1})
+				stmtStart = -1
+				continue
+			}
+		}
+		if stmtStart < 0 {
+			stmtStart = i
+		}
+	}
+	if stmtStart >= 0 {
+		statements = append(statements, [2]int{stmtStart, end})
+	}
+	return statements
+}
+
+// blockBody returns offsets of block contents in "key = { contents }" statement.
+// Block with no closing brace continues to the end of the statement.
+func blockBody(b []byte, start, end int) (bodyStart, bodyEnd int, ok bool) {
+	i := bytes.IndexByte(b[start:end], '{')
+	if i < 0 {
+		return 0, 0, false
+	}
+	bodyStart = start + i + 1
+	bodyEnd = end
+	if bytes.HasSuffix(bytes.TrimSpace(b[bodyStart:end]), []byte("}")) {
+		bodyEnd = start + bytes.LastIndexByte(b[start:end], '}')
+	}
+	return bodyStart, bodyEnd, true
+}
+
+// parseYML parses localisation file contents.
+// In lenient mode lines that can't be parsed are skipped and reported as warnings.
+func parseYML(parser *ptool.TParser, f, path string) (*ptool.TNode, error) {
+	node, err := parser.Parse(f)
+	if err == nil || !isLenientParsing {
+		return node, err
+	}
+
+	b := []byte(f)
+	printParseWarnings(repairYML(parser, b, path))
+	return parser.Parse(string(b))
+}
+
+// repairYML blanks out lines of b that can't be parsed and returns warnings about them.
+func repairYML(parser *ptool.TParser, b []byte, path string) []string {
+	var warnings []string
+	header := ""
+	for start := 0; start < len(b); {
+		end := bytes.IndexByte(b[start:], '\n')
+		if end < 0 {
+			end = len(b)
+		} else {
+			end += start
+		}
+
+		line := strings.TrimSpace(string(b[start:end]))
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case header == "":
+			// Language header must stay in place, nothing can be parsed without it.
+			header = string(b[start:end])
+		default:
+			_, err := parser.Parse(header + "\n" + string(b[start:end]))
+			if err != nil {
+				warnings = append(warnings, parseWarning(path, b, start, end, err))
+				blank(b, start, end)
+			}
+		}
+		start = end + 1
+	}
+	return warnings
+}
+
+// blank replaces b[start:end] with spaces, keeping line breaks so line numbers are not affected.
+func blank(b []byte, start, end int) {
+	for i := start; i < end; i++ {
+		if b[i] != '\n' && b[i] != '\r' {
+			b[i] = ' '
+		}
+	}
+}
+
+// parseWarning describes skipped b[start:end] with its line numbers.
+func parseWarning(path string, b []byte, start, end int, err error) string {
+	first := bytes.Count(b[:start], []byte("\n")) + 1
+	last := first + bytes.Count(b[start:end], []byte("\n"))
+	lines := fmt.Sprintf("%d", first)
+	if last != first {
+		lines = fmt.Sprintf("%d-%d", first, last)
+	}
+	return fmt.Sprintf("%v:%v: skipped: %v", path, lines, err)
+}
+
+func printParseWarnings(warnings []string) {
+	for _, w := range warnings {
+		ansi.Println("\x1b[33m" + w + "\x1b[0m")
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePDXLenient(t *testing.T) {
+	err := buildParsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		f        string
+		ids      []string
+		warnings []string
+	}{
+		{
+			name: "valid file",
+			f: `focus_tree = {
+	focus = { id = A }
+	focus = { id = B }
+}`,
+			ids: []string{"A", "B"},
+		},
+		{
+			name: "truncated block",
+			f: `focus_tree = {
+	focus = { id = A }
+	focus = {
+		id = B
+		x =`,
+			ids:      []string{"A"},
+			warnings: []string{"test.txt:3-5"},
+		},
+		{
+			name: "broken line skips whole focus",
+			f: `focus_tree = {
+	focus = { id = A }
+	focus = {
+		id = B
+		cost = = 5
+	}
+	focus = { id = C }
+}`,
+			ids:      []string{"A", "C"},
+			warnings: []string{"test.txt:3-6"},
+		},
+		{
+			name: "stray closing brace",
+			f: `focus_tree = {
+	focus = { id = A }
+	}
+	focus = { id = B }
+}`,
+			ids:      []string{"A", "B"},
+			warnings: []string{"test.txt:5"},
+		},
+		{
+			name: "unclosed quote",
+			f: `focus_tree = {
+	focus = { id = A }
+	focus = {
+		id = B
+		icon = "GFX_b
+	}
+	focus = { id = C }
+}`,
+			ids:      []string{"A", "C"},
+			warnings: []string{"test.txt:3-6"},
+		},
+		{
+			name: "broken top level statement",
+			f: `= 5
+focus = { id = A }`,
+			ids:      []string{"A"},
+			warnings: []string{"test.txt:1"},
+		},
+	}
+
+	isLenientParsing = true
+	defer func() { isLenientParsing = false }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := []byte(tt.f)
+			warnings := repairPDX(pdx, b, "test.txt")
+			if len(warnings) != len(tt.warnings) {
+				t.Fatalf("got warnings %q, want %q", warnings, tt.warnings)
+			}
+			for i, w := range warnings {
+				if !strings.HasPrefix(w, tt.warnings[i]+": skipped") {
+					t.Errorf("got warning %q, want %q", w, tt.warnings[i])
+				}
+			}
+
+			node, err := parsePDX(pdx, tt.f, "test.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := traverseFocusIDs(node)
+			if !reflect.DeepEqual(ids, tt.ids) {
+				t.Errorf("got focuses %q, want %q", ids, tt.ids)
+			}
+		})
+	}
+}
+
+func TestParsePDXStrict(t *testing.T) {
+	err := buildParsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = parsePDX(pdx, "focus = { id = A }\n}", "test.txt")
+	if err == nil {
+		t.Error("stray closing brace is parsed without lenient mode")
+	}
+}
+
+func TestParseYMLLenient(t *testing.T) {
+	err := buildParsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := `l_english:
+ A:0 "a"
+ broken line
+ B:0 "b"`
+
+	isLenientParsing = true
+	defer func() { isLenientParsing = false }()
+
+	warnings := repairYML(yml, []byte(f), "test.yml")
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "test.yml:3: skipped") {
+		t.Errorf("got warnings %q, want test.yml:3", warnings)
+	}
+
+	node, err := parseYML(yml, f, "test.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := ParsedFile{Loc: make(map[string][]Localisation)}
+	err = traverseLoc(node, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, l := range p.Loc["l_english"] {
+		keys = append(keys, l.Key)
+	}
+	if !reflect.DeepEqual(keys, []string{"A", "B"}) {
+		t.Errorf("got keys %q, want A and B", keys)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/k0kubun/go-ansi"
+)
+
+// locValue returns localisation value of the key with $KEY$ references resolved.
+func locValue(key string) (string, bool) {
+	l, ok := locMap[language][key]
+	if !ok {
+		return "", false
+	}
+	return resolveLocReferences(l.Value, []string{key}), true
+}
+
+// resolveLocReferences replaces $KEY$ references in the text with their localisation values.
+// Formatting suffixes like $KEY|Y$ are dropped. Unresolved and cyclic references are replaced with their keys and reported.
+// chain holds keys that are being resolved.
+func resolveLocReferences(text string, chain []string) string {
+	var b strings.Builder
+	for {
+		start, end, key := nextLocReference(text)
+		if start < 0 {
+			break
+		}
+		b.WriteString(text[:start])
+
+		l, ok := locMap[language][key]
+		switch {
+		case containsString(chain, key):
+			locWarnings["localisation reference cycle: "+strings.Join(append(chain[:len(chain):len(chain)], key), " -> ")] = true
+			b.WriteString(key)
+		case !ok:
+			if len(chain) > 0 {
+				locWarnings["localisation key \""+key+"\" referenced in \""+chain[len(chain)-1]+"\" not found"] = true
+			} else {
+				locWarnings["localisation key \""+key+"\" not found"] = true
+			}
+			b.WriteString(key)
+		default:
+			b.WriteString(resolveLocReferences(l.Value, append(chain[:len(chain):len(chain)], key)))
+		}
+		text = text[end:]
+	}
+	b.WriteString(text)
+	return resolveScriptedLoc(b.String(), chain)
+}
+
+// resolveScriptedLoc replaces [GetName] calls of scripted localisation in the text with values of the picked keys.
+// Calls that are not defined in scripted localisation are left as they are.
+func resolveScriptedLoc(text string, chain []string) string {
+	var b strings.Builder
+	for {
+		start, end, name := nextScriptedLocCall(text)
+		if start < 0 {
+			break
+		}
+		b.WriteString(text[:start])
+
+		key, ok := scriptedLocKey(name)
+		if !ok {
+			b.WriteString(text[start:end])
+			text = text[end:]
+			continue
+		}
+		l, ok := locMap[language][key]
+		switch {
+		case containsString(chain, key):
+			locWarnings["localisation reference cycle: "+strings.Join(append(chain[:len(chain):len(chain)], key), " -> ")] = true
+			b.WriteString(key)
+		case !ok:
+			locWarnings["localisation key \""+key+"\" of scripted localisation \""+name+"\" not found"] = true
+			b.WriteString(key)
+		default:
+			b.WriteString(resolveLocReferences(l.Value, append(chain[:len(chain):len(chain)], key)))
+		}
+		text = text[end:]
+	}
+	b.WriteString(text)
+	return b.String()
+}
+
+// nextScriptedLocCall returns position of the first [Scope.GetName] call in the text and its name without scopes.
+// start is -1 if there are no calls.
+func nextScriptedLocCall(text string) (start, end int, name string) {
+	start = strings.IndexByte(text, '[')
+	if start < 0 {
+		return -1, -1, ""
+	}
+	end = strings.IndexByte(text[start:], ']')
+	if end < 0 {
+		return -1, -1, ""
+	}
+	end += start + 1
+
+	name = text[start+1 : end-1]
+	if i := strings.IndexByte(name, '|'); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	return start, end, name
+}
+
+// scriptedLocCalls returns names of scripted localisation called in the text.
+func scriptedLocCalls(text string) []string {
+	var names []string
+	for {
+		start, end, name := nextScriptedLocCall(text)
+		if start < 0 {
+			return names
+		}
+		names = append(names, name)
+		text = text[end:]
+	}
+}
+
+// scriptedLocKey returns localisation key picked for the scripted localisation name.
+// User choice goes first, then the first text with a true or missing trigger, like the game does.
+// Texts with triggers that could not be evaluated are skipped and the fallback use is reported.
+func scriptedLocKey(name string) (string, bool) {
+	if key, ok := scriptedLocChoices[name]; ok {
+		return key, true
+	}
+	s, ok := scriptedLocMap[name]
+	if !ok || len(s.Texts) == 0 {
+		return "", false
+	}
+
+	isSkipped := false
+	for _, t := range s.Texts {
+		switch {
+		case !t.HasTrigger, t.Known && t.Result:
+			if isSkipped {
+				locWarnings["scripted localisation \""+name+"\" uses \""+t.Key+"\", pick another text in \"Select scripted localisation\" if needed"] = true
+			}
+			return t.Key, true
+		case !t.Known:
+			isSkipped = true
+		}
+	}
+	// Nothing matched, the last text is used as a fallback.
+	t := s.Texts[len(s.Texts)-1]
+	locWarnings["scripted localisation \""+name+"\" has no matching text, \""+t.Key+"\" is used"] = true
+	return t.Key, true
+}
+
+// nextLocReference returns position of the first $KEY$ reference in the text and its key without formatting suffix.
+// start is -1 if there are no references.
+func nextLocReference(text string) (start, end int, key string) {
+	offset := 0
+	for {
+		start = strings.IndexByte(text[offset:], '$')
+		if start < 0 {
+			return -1, -1, ""
+		}
+		start += offset
+		end = strings.IndexByte(text[start+1:], '$')
+		if end < 0 {
+			return -1, -1, ""
+		}
+		end += start + 2
+
+		key = text[start+1 : end-1]
+		if i := strings.IndexByte(key, '|'); i >= 0 {
+			key = key[:i]
+		}
+		// Dollar signs around text with spaces are not references.
+		if key != "" && !strings.ContainsAny(key, " \t") {
+			return start, end, key
+		}
+		offset = end - 1
+	}
+}
+
+// locReferences returns keys referenced in the text.
+func locReferences(text string) []string {
+	var keys []string
+	for {
+		start, end, key := nextLocReference(text)
+		if start < 0 {
+			return keys
+		}
+		keys = append(keys, key)
+		text = text[end:]
+	}
+}
+
+// parseReferencedLoc loads localisation keys referenced with $KEY$ and used by scripted localisation, until no new keys are found.
+func parseReferencedLoc() error {
+	requested := make(map[string]bool, len(locList))
+	for _, key := range locList {
+		requested[key] = true
+	}
+
+	for {
+		var missing []string
+		for _, l := range locMap[language] {
+			keys := locReferences(l.Value)
+			for _, name := range scriptedLocCalls(l.Value) {
+				// Scripted localisation is parsed only if it is used.
+				if scriptedLocMap == nil {
+					err := parseScriptedLoc()
+					if err != nil {
+						return err
+					}
+				}
+				// Every text is loaded, so user can pick any of them.
+				for _, t := range scriptedLocMap[name].Texts {
+					keys = append(keys, t.Key)
+				}
+				if key, ok := scriptedLocChoices[name]; ok {
+					keys = append(keys, key)
+				}
+			}
+			for _, key := range keys {
+				if _, ok := locMap[language][key]; !ok && !requested[key] {
+					requested[key] = true
+					missing = append(missing, key)
+				}
+			}
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+
+		sort.Strings(missing)
+		locList = append(locList, missing...)
+		err := parseLoc()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// languageNames holds names of the languages the game ships with.
+var languageNames = map[string]string{
+	"l_english":      "English",
+	"l_braz_por":     "Brazilian Portuguese",
+	"l_german":       "German",
+	"l_french":       "French",
+	"l_spanish":      "Spanish",
+	"l_polish":       "Polish",
+	"l_russian":      "Russian",
+	"l_japanese":     "Japanese",
+	"l_korean":       "Korean",
+	"l_simp_chinese": "Simplified Chinese",
+}
+
+// locLanguages returns languages found in localisation file headers of the paths and how many of the keys each one defines.
+// Files are read through localisation name index, so only changed files are scanned again.
+func locLanguages(paths, keys []string) (map[string]int, error) {
+	loadParseCache()
+
+	wanted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		wanted[key] = true
+	}
+	covered := make(map[string]map[string]bool)
+	for _, path := range paths {
+		dir := filepath.Join(path, "localisation")
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		_, err := indexedFiles(locIndex, dir, ".yml", locKeys, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range locIndex[dir].Files {
+			if !strings.HasPrefix(entry.Language, "l_") {
+				continue
+			}
+			if _, ok := covered[entry.Language]; !ok {
+				covered[entry.Language] = make(map[string]bool)
+			}
+			for _, name := range entry.Names {
+				if wanted[name] {
+					covered[entry.Language][name] = true
+				}
+			}
+		}
+	}
+
+	coverage := make(map[string]int, len(covered))
+	for language, keys := range covered {
+		coverage[language] = len(keys)
+	}
+	return coverage, nil
+}
+
+// isLocReplacePath reports if the file is in replace folder, either localisation/replace or localisation/language/replace.
+func isLocReplacePath(rel string) bool {
+	dirs := strings.Split(rel, "/")
+	return containsString(dirs[:len(dirs)-1], "replace")
+}
+
+// printExplainedKey prints where the final value of explainKey comes from.
+func printExplainedKey() {
+	if explainKey == "" {
+		return
+	}
+	ansi.Println(explainedKey())
+}
+
+// explainedKey returns the final value of the --explain-key key with the file and line it comes from.
+func explainedKey() string {
+	l, ok := locMap[language][explainKey]
+	if !ok {
+		return "\x1b[33m" + "Localisation key \"" + explainKey + "\" is not defined in " + language + "\x1b[0m"
+	}
+	return "\x1b[33;1m" + explainKey + "\x1b[0m" + " = \"" + l.Value + "\" from " + l.File + ":" + strconv.Itoa(l.Line)
+}
+
+// printLocWarnings prints localisation problems found during rendering.
+func printLocWarnings() {
+	printWarnings(locWarnings)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveLocReferences(t *testing.T) {
+	locMap = map[string]map[string]Localisation{
+		"l_english": {
+			"BASE":   {Key: "BASE", Value: "Base"},
+			"NESTED": {Key: "NESTED", Value: "$BASE$ Reforms"},
+			"CYCLE1": {Key: "CYCLE1", Value: "a $CYCLE2$"},
+			"CYCLE2": {Key: "CYCLE2", Value: "b $CYCLE1$"},
+			"SELF":   {Key: "SELF", Value: "$SELF$"},
+		},
+	}
+	defer func() {
+		locMap = make(map[string]map[string]Localisation)
+		locWarnings = make(map[string]bool)
+	}()
+
+	tests := []struct {
+		name     string
+		text     string
+		want     string
+		warnings []string
+	}{
+		{"no references", "Focus", "Focus", nil},
+		{"reference", "$BASE$ Army", "Base Army", nil},
+		{"nested reference", "The $NESTED$", "The Base Reforms", nil},
+		{"formatting suffix", "$BASE|Y$ and $BASE|R2$", "Base and Base", nil},
+		{"dollar signs around spaces", "costs $ 5 $ and $BASE$", "costs $ 5 $ and Base", nil},
+		{"unclosed dollar sign", "$BASE", "$BASE", nil},
+		{"missing key", "$MISSING$", "MISSING", []string{`localisation key "MISSING" not found`}},
+		{"cycle", "$CYCLE1$", "a b CYCLE1", []string{"localisation reference cycle: CYCLE1 -> CYCLE2 -> CYCLE1"}},
+		{"self reference", "$SELF$", "SELF", []string{"localisation reference cycle: SELF -> SELF"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			locWarnings = make(map[string]bool)
+			got := resolveLocReferences(tt.text, nil)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			var warnings []string
+			for w := range locWarnings {
+				warnings = append(warnings, w)
+			}
+			if !reflect.DeepEqual(warnings, tt.warnings) {
+				t.Errorf("got warnings %q, want %q", warnings, tt.warnings)
+			}
+		})
+	}
+
+	// Missing keys referenced by other keys are reported with them.
+	locMap["l_english"]["BROKEN"] = Localisation{Key: "BROKEN", Value: "$MISSING$"}
+	locWarnings = make(map[string]bool)
+	if got, _ := locValue("BROKEN"); got != "MISSING" {
+		t.Errorf("got %q, want MISSING", got)
+	}
+	if !locWarnings[`localisation key "MISSING" referenced in "BROKEN" not found`] {
+		t.Errorf("got warnings %v", locWarnings)
+	}
+}
+
+func TestScriptedLocKey(t *testing.T) {
+	scriptedLocMap = map[string]ScriptedLoc{
+		"GetNoTrigger": {Name: "GetNoTrigger", Texts: []ScriptedLocText{
+			{Key: "FALSE", HasTrigger: true, Known: true},
+			{Key: "DEFAULT"},
+			{Key: "TRUE", HasTrigger: true, Known: true, Result: true},
+		}},
+		"GetTrue": {Name: "GetTrue", Texts: []ScriptedLocText{
+			{Key: "FALSE", HasTrigger: true, Known: true},
+			{Key: "TRUE", HasTrigger: true, Known: true, Result: true},
+		}},
+		"GetSkipped": {Name: "GetSkipped", Texts: []ScriptedLocText{
+			{Key: "UNKNOWN", HasTrigger: true},
+			{Key: "TRUE", HasTrigger: true, Known: true, Result: true},
+		}},
+		"GetNothing": {Name: "GetNothing", Texts: []ScriptedLocText{
+			{Key: "FALSE", HasTrigger: true, Known: true},
+			{Key: "LAST", HasTrigger: true},
+		}},
+		"GetEmpty": {Name: "GetEmpty"},
+	}
+	scriptedLocChoices = map[string]string{"GetPicked": "PICKED", "GetTrue": "OVERRIDE"}
+	defer func() {
+		scriptedLocMap = nil
+		scriptedLocChoices = make(map[string]string)
+		locWarnings = make(map[string]bool)
+	}()
+
+	tests := []struct {
+		name     string
+		want     string
+		ok       bool
+		warnings []string
+	}{
+		{"GetPicked", "PICKED", true, nil},
+		{"GetTrue", "OVERRIDE", true, nil},
+		{"GetNoTrigger", "DEFAULT", true, nil},
+		{"GetSkipped", "TRUE", true, []string{`scripted localisation "GetSkipped" uses "TRUE", pick another text in "Select scripted localisation" if needed`}},
+		{"GetNothing", "LAST", true, []string{`scripted localisation "GetNothing" has no matching text, "LAST" is used`}},
+		{"GetEmpty", "", false, nil},
+		{"GetMissing", "", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			locWarnings = make(map[string]bool)
+			got, ok := scriptedLocKey(tt.name)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("got %q, %v, want %q, %v", got, ok, tt.want, tt.ok)
+			}
+			var warnings []string
+			for w := range locWarnings {
+				warnings = append(warnings, w)
+			}
+			if !reflect.DeepEqual(warnings, tt.warnings) {
+				t.Errorf("got warnings %q, want %q", warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestFocusTitleCoverage(t *testing.T) {
+	err := buildParsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	binPath = dir
+	parseCache = nil
+	defer func() {
+		binPath = ""
+		parseCache = nil
+		isParseCacheChanged = false
+		locIndex = make(map[string]*nameIndex)
+	}()
+
+	files := map[string]string{
+		"common/national_focus/tree.txt": "focus_tree = {\n" +
+			"\tfocus = { id = A }\n" +
+			"\tfocus = { id = B text = B_TITLE }\n" +
+			"\tshared_focus = { id = C }\n" +
+			"}\n",
+		"localisation/english/tree_l_english.yml": "l_english:\n A:0 \"A\"\n B:0 \"B\"\n B_TITLE:0 \"B title\"\n A_desc:0 \"A desc\"\n",
+		"localisation/german/tree_l_german.yml":   "l_german:\n A:0 \"A\"\n B:0 \"B\"\n C_desc:0 \"C desc\"\n",
+	}
+	for name, f := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(f), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Focus text key replaces its id, descriptions are not counted.
+	keys, err := focusTitleKeys(filepath.Join(dir, "common/national_focus/tree.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"A", "B_TITLE", "C"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("got title keys %q, want %q", keys, want)
+	}
+
+	coverage, err := locLanguages([]string{dir}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"l_english": 2, "l_german": 1}; !reflect.DeepEqual(coverage, want) {
+		t.Errorf("got coverage %v, want %v", coverage, want)
+	}
+}
+
+func TestLocLoadOrder(t *testing.T) {
+	err := buildParsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		binPath = ""
+		parseCache = nil
+		isParseCacheChanged = false
+		locIndex = make(map[string]*nameIndex)
+		locMap = make(map[string]map[string]Localisation)
+		modPaths = nil
+		locList = nil
+		explainKey = ""
+	}()
+
+	loc := func(value string) string {
+		return "l_english:\n KEY:0 \"" + value + "\"\n"
+	}
+	// Mods are loaded as game, mod_a, mod_b.
+	tests := []struct {
+		name  string
+		files map[string]string
+		value string
+		file  string
+	}{
+		{"later mod wins", map[string]string{
+			"game/localisation/english/a_l_english.yml":  loc("game"),
+			"mod_a/localisation/english/b_l_english.yml": loc("mod a"),
+		}, "mod a", "mod_a/localisation/english/b_l_english.yml"},
+		{"replace folder wins over later mod", map[string]string{
+			"mod_a/localisation/replace/a_l_english.yml": loc("replace"),
+			"mod_b/localisation/english/b_l_english.yml": loc("mod b"),
+		}, "replace", "mod_a/localisation/replace/a_l_english.yml"},
+		{"language replace folder wins over later mod", map[string]string{
+			"game/localisation/english/replace/a_l_english.yml": loc("replace"),
+			"mod_b/localisation/english/b_l_english.yml":        loc("mod b"),
+		}, "replace", "game/localisation/english/replace/a_l_english.yml"},
+		{"later replace folder wins", map[string]string{
+			"mod_a/localisation/replace/a_l_english.yml":         loc("replace a"),
+			"mod_b/localisation/english/replace/b_l_english.yml": loc("replace b"),
+		}, "replace b", "mod_b/localisation/english/replace/b_l_english.yml"},
+		{"replaced file is not loaded", map[string]string{
+			"game/localisation/english/a_l_english.yml":  loc("game"),
+			"mod_b/localisation/english/A_l_english.yml": "l_english:\n OTHER:0 \"other\"\n",
+		}, "", ""},
+		{"other language", map[string]string{
+			"game/localisation/english/a_l_english.yml": loc("game"),
+			"mod_b/localisation/german/b_l_german.yml":  "l_german:\n KEY:0 \"mod b\"\n",
+		}, "game", "game/localisation/english/a_l_english.yml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			binPath = dir
+			parseCache = nil
+			locIndex = make(map[string]*nameIndex)
+			locMap = make(map[string]map[string]Localisation)
+			modPaths = []string{filepath.Join(dir, "game"), filepath.Join(dir, "mod_a"), filepath.Join(dir, "mod_b")}
+			locList = []string{"KEY"}
+			explainKey = "KEY"
+			writeTestFiles(t, dir, tt.files)
+
+			files, err := locFiles()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, fPath := range files {
+				b, err := ioutil.ReadFile(fPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p, err := parseLocFile(yml, string(b), fPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				mergeLocFile(p)
+			}
+
+			want := "\x1b[33m" + `Localisation key "KEY" is not defined in l_english` + "\x1b[0m"
+			if tt.file != "" {
+				want = "\x1b[33;1m" + "KEY" + "\x1b[0m" + ` = "` + tt.value + `" from ` + filepath.Join(dir, tt.file) + ":2"
+			}
+			if got := explainedKey(); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
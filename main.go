@@ -3,44 +3,153 @@ package main
 import (
 	"bytes"
 	"encoding/gob"
+	"flag"
 	"image"
+	"image/color"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/widget"
+	"github.com/k0kubun/go-ansi"
 	"github.com/macroblock/imed/pkg/ptool"
-	"github.com/malashin/bmfonter"
-	_ "github.com/malashin/dds"
-
-	// TGA must be the last registered image format due to not having magic prefix.
-	// Every image file will be treated as TGA if registered magic is not found.
-	_ "github.com/ftrvxmtrx/tga"
 )
 
 var focusTreePaths, modPaths []string
 var gamePath, binPath string
-var running, isLineRenderingOff bool
+var running, isLineRenderingOff, isLenientParsing, isDurationRenderingOn bool
 var win fyne.Window
 var pBar *widget.ProgressBar
 
 var language = "l_english"
+
+// renderLanguages holds other languages focus trees are rendered in, together with the selected one.
+var renderLanguages []string
+
+// dlcList holds DLC names as they are checked by has_dlc trigger, it is filled by findDLCs.
+var dlcList []string
+
+// searchFilterList holds vanilla focus search filters.
+var searchFilterList = []string{
+	"FOCUS_FILTER_POLITICAL",
+	"FOCUS_FILTER_RESEARCH",
+	"FOCUS_FILTER_INDUSTRY",
+	"FOCUS_FILTER_STABILITY",
+	"FOCUS_FILTER_WAR_SUPPORT",
+	"FOCUS_FILTER_MANPOWER",
+	"FOCUS_FILTER_ANNEXATION",
+	"FOCUS_FILTER_HISTORICAL",
+	"FOCUS_FILTER_ARMY_XP",
+	"FOCUS_FILTER_NAVY_XP",
+	"FOCUS_FILTER_AIR_XP",
+	"FOCUS_FILTER_TFV_AUTONOMY",
+}
+
+// Focuses without any of the selectedSearchFilters are dimmed or hidden depending on searchFilterMode.
+var selectedSearchFilters []string
+var searchFilterMode string
+var isSearchFilterIconsOn bool
+
+// continuousFocusMode selects where the continuous focus panel is rendered.
+var continuousFocusMode = ContinuousFocusOff
+
+// Name indexes of gfx and localisation folders by folder path.
+var gfxIndex = make(map[string]*nameIndex)
+var locIndex = make(map[string]*nameIndex)
+
+// Decoded textures are cached by file path, sprite frames by sprite name and frame number.
+var textureCache = newImageCache(512 << 20)
+var frameCache = newImageCache(128 << 20)
+
+// bgStateFrame replaces focus background frame if it is higher then 0.
+var bgStateFrame int
+
+// focusFrameOverrides holds icon frames for focuses with multi-frame icons.
+var focusFrameOverrides = make(map[string]int)
+
+// ownedDLCs is nil until user picks the DLCs, every DLC is treated as owned in that case.
+var ownedDLCs map[string]bool
+
+// ownedDLCsFile keeps DLCs picked by user between runs, next to the binary.
+const ownedDLCsFile = "hoi4treesnapOwnedDLCs.txt"
+
 var spacingX = 131
 var spacingY = 63
 
+// focusTreeShift is the number of focus positions the tree is moved by to get rid of negative coordinates.
+var focusTreeShift image.Point
+
+// daysPerCost is the number of days one point of focus cost takes.
+var daysPerCost = defaultDaysPerCost
+
+const defaultDaysPerCost = 7.0
+
+// daysPerCostErr holds the error of the last days per cost input, images are not generated until it is fixed.
+var daysPerCostErr error
+
+// focusPaths are focus id chains whose summed durations are drawn under the tree and printed after the image is generated.
+var focusPaths [][]string
+
 var focusMap = make(map[string]Focus)
+var focusTree FocusTree
+var continuousFocusPaletteMap = make(map[string]ContinuousFocusPalette)
+var inlayWindowMap = make(map[string]InlayWindow)
+
+// searchFilterDefs holds search filters defined in common/search_filter_prios.
+var searchFilterDefs = make(map[string]bool)
 var gfxMap = make(map[string]SpriteType)
+
+// fontMap holds fonts by name, language overrides are stored by languageFontName.
 var fontMap = make(map[string]BitmapFont)
 var locMap = make(map[string]map[string]Localisation)
 var gui FocusGUI
-var font, fontTreeTitle bmfonter.Font
+var guiElementMap = make(map[string]*GUIElement)
+var guiBaseElementMap = make(map[string]*GUIElement)
+var font, fontTreeTitle *textFont
+
+// textFonts holds fonts loaded during the run by font name.
+var textFonts = make(map[string]*textFont)
+
+// textColors holds § color codes defined in gfx files.
+var textColors = make(map[string]color.NRGBA)
 var locList, gfxList []string
 
+// scriptedLocMap is nil until scripted localisation is parsed.
+var scriptedLocMap map[string]ScriptedLoc
+
+// scriptedLocChoices holds localisation keys picked by user for scripted localisation names.
+var scriptedLocChoices = make(map[string]string)
+
+// scriptedLocFile keeps scripted localisation choices between runs, next to the binary.
+const scriptedLocFile = "hoi4treesnapScriptedLoc.txt"
+
+// explainKey is the localisation key printed with the file and line its value comes from.
+var explainKey string
+
+// locWarnings holds unresolved localisation references found during rendering.
+var locWarnings = make(map[string]bool)
+
+// guiWarnings holds gui element problems found during rendering, like missing sprites.
+var guiWarnings = make(map[string]bool)
+
 var buf = new(bytes.Buffer)
 var e = gob.NewEncoder(buf)
 var d = gob.NewDecoder(buf)
 
+const (
+	SearchFilterOff  = ""
+	SearchFilterDim  = "dim"
+	SearchFilterHide = "hide"
+)
+
+const (
+	ContinuousFocusOff  = ""
+	ContinuousFocusTree = "tree"
+	ContinuousFocusSide = "side"
+)
+
 const (
 	U Dir = 1
 	D Dir = 2
@@ -84,8 +193,10 @@ type Focus struct {
 	X                  int
 	Y                  int
 	RelativePositionID string
+	Cost               float64
 	Prerequisite       [][]string
 	MutuallyExclusive  []string
+	SearchFilters      []string
 	AllowBranch        bool
 	Available          bool
 	Children           []Child
@@ -93,6 +204,53 @@ type Focus struct {
 	Out                FocusLine
 }
 
+type FocusTree struct {
+	ID                         string
+	Tag                        string
+	ContinuousFocusPosition    image.Point
+	HasContinuousFocusPosition bool
+	InlayWindows               []InlayWindowRef
+}
+
+type InlayWindowRef struct {
+	ID       string
+	Position image.Point
+}
+
+type InlayWindow struct {
+	ID             string
+	WindowName     string
+	Visible        bool
+	ScriptedImages map[string]string
+	HiddenButtons  []string
+}
+
+// ScriptedLoc is a defined_text from common/scripted_localisation.
+type ScriptedLoc struct {
+	Name  string
+	Texts []ScriptedLocText
+}
+
+// ScriptedLocText is a text alternative of scripted localisation.
+// Known is false if the trigger could not be evaluated.
+type ScriptedLocText struct {
+	Key        string
+	HasTrigger bool
+	Result     bool
+	Known      bool
+}
+
+type ContinuousFocusPalette struct {
+	ID      string
+	Default bool
+	Focuses []ContinuousFocus
+}
+
+type ContinuousFocus struct {
+	ID   string
+	Icon string
+}
+
 type Child struct {
 	ID    string
 	Solid bool
@@ -104,26 +262,72 @@ type FocusLine struct {
 
 type Dir int
 
+// SpriteType holds any of the sprite definitions from .gfx files, Type is the lowercased block name.
+// maskedShieldType and progressbartype have no texturefile, their TextureFile is set to TextureFile1.
 type SpriteType struct {
-	Name        string
-	TextureFile string
-	NoOfFrames  int
-	Image       image.Image
+	Type              string
+	Name              string
+	TextureFile       string
+	TextureFile1      string
+	TextureFile2      string
+	EffectFile        string
+	NoOfFrames        int
+	BorderSize        image.Point
+	Size              image.Point
+	LoadType          string
+	AlwaysTransparent bool
+	Horizontal        bool
+	TilingCenter      bool
+	Color             color.NRGBA
+	Color2            color.NRGBA
+	AnimationRateFPS  float64
+	Looping           bool
+	PlayOnShow        bool
+	PauseOnLoop       float64
+	Animations        []SpriteAnimation
+	Image             image.Image
+	// texturePath is the resolved path of the texture Image is decoded from.
+	texturePath string
+}
+
+type SpriteAnimation struct {
+	MaskFile       string
+	TextureFile    string
+	Rotation       float64
+	RotationOffset FloatPoint
+	TextureScale   FloatPoint
+	Looping        bool
+	Time           float64
+	Delay          float64
+	BlendMode      string
+	Type           string
+	Frames         []int
+}
+
+type FloatPoint struct {
+	X float64
+	Y float64
 }
 
 type BitmapFont struct {
 	Name      string
 	Path      string
 	Fontfiles []string
+	// Languages the bitmapfont_override is used for.
+	Languages []string
 }
 
+// Localisation holds the key with the file and line it was defined at.
 type Localisation struct {
 	Key    string
 	Number string
 	Value  string
+	File   string
+	Line   int
 }
 
 type FocusGUI struct {
+	Item                       *GUIElement
 	NationalFocusTitle         InstantTextboxType
 	NationalFocusItem          ContainerWindowType
 	BG                         ButtonType
@@ -147,6 +351,16 @@ type FocusGUI struct {
 	ExclusivePositioning       image.Point
 }
 
+// GUIElement is a generic element of the .gui file, like containerWindowType or iconType.
+// Property keys are lowercased, keys of the nested blocks are joined with dots, like "position.x".
+type GUIElement struct {
+	Type       string
+	Name       string
+	File       string
+	Properties map[string]string
+	Children   []*GUIElement
+}
+
 type InstantTextboxType struct {
 	Name              string
 	Position          image.Point
@@ -170,6 +384,7 @@ type ButtonType struct {
 	Name           string
 	Position       image.Point
 	SpriteType     string
+	Frame          int
 	CenterPosition string
 	Orientation    string
 }
@@ -188,6 +403,38 @@ func main() {
 	}
 	binPath = filepath.Dir(bin)
 
+	// DLCs passed with --dlc replace the saved ones for this run.
+	err = loadSavedOwnedDLCs()
+	if err != nil {
+		ansi.Println("\x1b[31;1m" + err.Error() + "\x1b[0m")
+	}
+	flag.Func("dlc", "comma separated names of owned DLCs, every DLC is owned by default", func(s string) error {
+		ownedDLCs = make(map[string]bool)
+		for _, dlc := range strings.Split(s, ",") {
+			if dlc = strings.TrimSpace(dlc); dlc != "" {
+				ownedDLCs[dlc] = true
+			}
+		}
+		return nil
+	})
+	flag.Func("path", "comma separated focus ids to print the summed duration of, can be repeated", func(s string) error {
+		var ids []string
+		for _, id := range strings.Split(s, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+		focusPaths = append(focusPaths, ids)
+		return nil
+	})
+	flag.StringVar(&explainKey, "explain-key", "", "print the file and line the final value of the localisation key comes from")
+	flag.Parse()
+
+	err = loadSavedScriptedLocChoices()
+	if err != nil {
+		ansi.Println("\x1b[31;1m" + err.Error() + "\x1b[0m")
+	}
+
 	app := app.New()
 	setupUI(app)
 }
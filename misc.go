@@ -1,13 +1,17 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"io/fs"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	"fyne.io/fyne/v2"
@@ -16,7 +20,6 @@ import (
 	"fyne.io/fyne/v2/widget"
 	"github.com/k0kubun/go-ansi"
 	"github.com/macroblock/imed/pkg/ptool"
-	"github.com/malashin/bmfonter"
 )
 
 func nodesToString(node *ptool.TNode) []string {
@@ -78,24 +81,53 @@ func decodeCacheFile(i interface{}, path string) error {
 	return nil
 }
 
-func fillAbsoluteFocusPositions(finished bool) bool {
-	for _, f1 := range focusMap {
-		if f1.RelativePositionID == "" {
-			for _, f2 := range focusMap {
-				if f2.RelativePositionID == f1.ID {
-					f2.X += focusMap[f1.ID].X
-					f2.Y += focusMap[f1.ID].Y
-					f2.RelativePositionID = ""
-					focusMap[f2.ID] = f2
-					finished = false
-				}
-			}
+// fillAbsoluteFocusPositions calculates coordinates of focuses with relative positions.
+// Each focus is resolved after the one it is positioned relative to.
+func fillAbsoluteFocusPositions() error {
+	ids := make([]string, 0, len(focusMap))
+	for id := range focusMap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	resolved := make(map[string]bool)
+	for _, id := range ids {
+		err := resolveFocusPosition(id, resolved, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveFocusPosition adds coordinates of relative_position_id focus to the focus coordinates.
+// chain holds focuses waiting for this one to be resolved and is used to detect cycles.
+func resolveFocusPosition(id string, resolved map[string]bool, chain []string) error {
+	if resolved[id] {
+		return nil
+	}
+	for i, c := range chain {
+		if c == id {
+			return fmt.Errorf("relative_position_id cycle: %v", strings.Join(append(chain[i:], id), " -> "))
 		}
 	}
-	if !finished {
-		finished = fillAbsoluteFocusPositions(true)
+
+	f := focusMap[id]
+	if f.RelativePositionID != "" {
+		if _, ok := focusMap[f.RelativePositionID]; !ok {
+			return fmt.Errorf("focus %q has relative_position_id %q that is not found", id, f.RelativePositionID)
+		}
+		err := resolveFocusPosition(f.RelativePositionID, resolved, append(chain, id))
+		if err != nil {
+			return err
+		}
+		r := focusMap[f.RelativePositionID]
+		f.X += r.X
+		f.Y += r.Y
+		focusMap[id] = f
 	}
-	return finished
+	resolved[id] = true
+	return nil
 }
 
 func moveAbsoluteFocusPositionsToPositiveValues() {
@@ -114,6 +146,7 @@ func moveAbsoluteFocusPositionsToPositiveValues() {
 		}
 	}
 
+	focusTreeShift = image.Point{-lowestX, -lowestY}
 	if lowestX < 0 || lowestY < 0 {
 		for _, f := range focusMap {
 			f.X -= lowestX
@@ -124,6 +157,12 @@ func moveAbsoluteFocusPositionsToPositiveValues() {
 	}
 }
 
+// focusTreePoint converts position relative to the focus tree origin to image coordinates.
+// The origin is where the focus with x = 0 and y = 0 is drawn.
+func focusTreePoint(p image.Point) image.Point {
+	return p.Add(image.Point{focusTreeShift.X*gui.FocusSpacing.X + spacingX, focusTreeShift.Y*gui.FocusSpacing.Y + spacingY})
+}
+
 // buildFocusTree adds children data to each focus.
 // Sorts children by X coordinate from left to right.
 func fillFocusChildAndParentData() {
@@ -148,9 +187,12 @@ func fillFocusChildAndParentData() {
 	}
 
 	for _, p := range focusMap {
+		if !isFocusShown(p) {
+			continue
+		}
 		for i, child := range p.Children {
 			c := focusMap[child.ID]
-			if !c.AllowBranch {
+			if !isFocusShown(c) {
 				continue
 			}
 
@@ -169,6 +211,9 @@ func fillFocusChildAndParentData() {
 			} else {
 				for _, child2 := range p.Children {
 					c2 := focusMap[child2.ID]
+					if !isFocusShown(c2) {
+						continue
+					}
 					switch {
 					case c.X < p.X && c2.X < c.X && child2.Solid:
 						a.Set(S)
@@ -189,10 +234,10 @@ func fillFocusChildAndParentData() {
 				p.Out.Set(U | L)
 			case c.X == p.X:
 				a.Set(U | D)
-				if i > 0 && focusMap[p.Children[i-1].ID].AllowBranch {
+				if i > 0 && isFocusShown(focusMap[p.Children[i-1].ID]) {
 					a.Set(L)
 				}
-				if i != len(p.Children)-1 && focusMap[p.Children[i+1].ID].AllowBranch {
+				if i != len(p.Children)-1 && isFocusShown(focusMap[p.Children[i+1].ID]) {
 					a.Set(R)
 				}
 				p.Out.Set(U | D)
@@ -207,7 +252,7 @@ func fillFocusChildAndParentData() {
 			for _, pSlice := range c.Prerequisite {
 				for _, p2 := range pSlice {
 					p2 := focusMap[p2]
-					if p.Y > p2.Y {
+					if isFocusShown(p2) && p.Y > p2.Y {
 						a.Set(U)
 					}
 				}
@@ -275,6 +320,15 @@ func (l *FocusLine) Get() image.Image {
 	return nil
 }
 
+func minInt(a int, b ...int) int {
+	for _, n := range b {
+		if n < a {
+			a = n
+		}
+	}
+	return a
+}
+
 func containsInt(s []int, a int) bool {
 	for _, b := range s {
 		if a == b {
@@ -319,6 +373,151 @@ func maxFocusPos(m map[string]Focus) (x, y int) {
 	return
 }
 
+// isFocusShown reports if focus and its lines are drawn.
+// Focuses without selected search filters are hidden in SearchFilterHide mode.
+func isFocusShown(f Focus) bool {
+	if !f.AllowBranch {
+		return false
+	}
+	return searchFilterMode != SearchFilterHide || hasSearchFilter(f)
+}
+
+// hasSearchFilter reports if focus has any of the selected search filters.
+func hasSearchFilter(f Focus) bool {
+	for _, filter := range f.SearchFilters {
+		if containsString(selectedSearchFilters, filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchFilterSpriteName returns name of the search filter icon sprite, the game uses GFX_ prefixed filter name.
+func searchFilterSpriteName(filter string) string {
+	return "GFX_" + filter
+}
+
+// searchFilterIcon returns icon sprite of the search filter defined in common/search_filter_prios.
+func searchFilterIcon(filter string) (SpriteType, error) {
+	if !searchFilterDefs[filter] {
+		return SpriteType{}, fmt.Errorf("search filter %q is not defined in common/search_filter_prios", filter)
+	}
+	s, ok := gfxMap[searchFilterSpriteName(filter)]
+	if !ok {
+		return SpriteType{}, fmt.Errorf("search filter %q icon %q not found", filter, searchFilterSpriteName(filter))
+	}
+	return s, nil
+}
+
+// printUnresolvedSearchFilters reports search filters of the focuses that have no icon.
+func printUnresolvedSearchFilters() {
+	var filters []string
+	for _, f := range focusMap {
+		for _, filter := range f.SearchFilters {
+			if !containsString(filters, filter) {
+				filters = append(filters, filter)
+			}
+		}
+	}
+	sort.Strings(filters)
+	for _, filter := range filters {
+		_, err := searchFilterIcon(filter)
+		if err != nil {
+			ansi.Println("\x1b[33m" + err.Error() + "\x1b[0m")
+		}
+	}
+}
+
+// activeContinuousFocusPalette returns the default continuous focus palette.
+// Palette with the lowest ID is used if none of them are marked as default.
+func activeContinuousFocusPalette() (ContinuousFocusPalette, bool) {
+	var ids []string
+	for id, p := range continuousFocusPaletteMap {
+		if p.Default {
+			return p, true
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return ContinuousFocusPalette{}, false
+	}
+	sort.Strings(ids)
+	return continuousFocusPaletteMap[ids[0]], true
+}
+
+// focusDays returns the number of days it takes to complete the focus.
+func focusDays(f Focus) int {
+	return int(math.Round(f.Cost * daysPerCost))
+}
+
+// pathDays returns summed duration of focuses in ids.
+// Each focus in the path must have the previous one as a prerequisite.
+func pathDays(ids []string) (int, error) {
+	var days int
+	for i, id := range ids {
+		f, ok := focusMap[id]
+		if !ok {
+			return 0, fmt.Errorf("focus id %q not found", id)
+		}
+		if i > 0 && !isPrerequisite(f, ids[i-1]) {
+			return 0, fmt.Errorf("focus %q is not a prerequisite of %q", ids[i-1], id)
+		}
+		days += focusDays(f)
+	}
+	return days, nil
+}
+
+// pathDurations returns days of every focus in focusPaths and their summed duration, one line per path.
+// Paths that can't be followed are returned as errors.
+func pathDurations() (lines []string, errs []error) {
+	for _, ids := range focusPaths {
+		total, err := pathDays(ids)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		days := make([]string, len(ids))
+		for i, id := range ids {
+			days[i] = fmt.Sprintf("%v (%v d)", id, focusDays(focusMap[id]))
+		}
+		lines = append(lines, fmt.Sprintf("%v = %v days", strings.Join(days, " -> "), total))
+	}
+	return lines, errs
+}
+
+// printPathDurations prints days of every focus in focusPaths and their summed duration.
+func printPathDurations() {
+	lines, errs := pathDurations()
+	for _, err := range errs {
+		ansi.Println("\x1b[33m" + "Path duration: " + err.Error() + "\x1b[0m")
+	}
+	for _, line := range lines {
+		ansi.Println("Path duration: " + line)
+	}
+}
+
+// parseDaysPerCost reads days per focus cost entered by user, empty text restores the default.
+func parseDaysPerCost(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return defaultDaysPerCost, nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("days per focus cost %q must be a number higher than 0", s)
+	}
+	return n, nil
+}
+
+func isPrerequisite(f Focus, id string) bool {
+	for _, g := range f.Prerequisite {
+		if containsString(g, id) {
+			return true
+		}
+	}
+	return false
+}
+
 func fillAllowBranchData(f Focus) {
 	if !f.AllowBranch {
 		for _, child := range f.Children {
@@ -355,6 +554,145 @@ func maxYinRange(m map[int]FocusLine, y int) int {
 	return max
 }
 
+// isDLCOwned reports if has_dlc trigger with given DLC name is true.
+// Every DLC is owned until user picks the owned ones.
+func isDLCOwned(name string) bool {
+	if ownedDLCs == nil {
+		return true
+	}
+	return ownedDLCs[name]
+}
+
+// saveOwnedDLCs saves DLCs picked by user for the following runs, one name per line.
+func saveOwnedDLCs() error {
+	var names []string
+	for dlc := range ownedDLCs {
+		names = append(names, dlc)
+	}
+	sort.Strings(names)
+	return ioutil.WriteFile(filepath.Join(binPath, ownedDLCsFile), []byte(strings.Join(names, "\n")), 0644)
+}
+
+// loadSavedOwnedDLCs reads DLCs picked by user during previous runs.
+// Every DLC stays owned if they were never picked.
+func loadSavedOwnedDLCs() error {
+	b, err := ioutil.ReadFile(filepath.Join(binPath, ownedDLCsFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ownedDLCs = make(map[string]bool)
+	for _, dlc := range strings.Split(string(b), "\n") {
+		if dlc = strings.TrimSpace(dlc); dlc != "" {
+			ownedDLCs[dlc] = true
+		}
+	}
+	return nil
+}
+
+var dlcNameRegexp = regexp.MustCompile(`(?m)^\s*name\s*=\s*"([^"]+)"`)
+var hasDLCRegexp = regexp.MustCompile(`has_dlc\s*=\s*(?:"([^"]+)"|([^\s{}"]+))`)
+
+// findDLCs returns names of the DLCs from .dlc files of the game folder and has_dlc triggers of selected focus trees.
+func findDLCs() ([]string, error) {
+	var names []string
+	dir := filepath.Join(gamePath, "dlc")
+	if _, err := os.Stat(dir); gamePath != "" && err == nil {
+		files, err := WalkMatchExt(dir, ".dlc")
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range files {
+			f, err := readFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if m := dlcNameRegexp.FindStringSubmatch(f); m != nil && !containsString(names, m[1]) {
+				names = append(names, m[1])
+			}
+		}
+	}
+	for _, path := range focusTreePaths {
+		f, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range hasDLCRegexp.FindAllStringSubmatch(f, -1) {
+			if name := m[1] + m[2]; !containsString(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// loadSavedGamePath reads the game path saved during previous runs if it is not selected yet.
+func loadSavedGamePath() error {
+	if gamePath != "" {
+		return nil
+	}
+	p := filepath.Join(binPath, "hoi4treesnapGamePath.txt")
+	if _, err := os.Stat(p); err != nil {
+		return nil
+	}
+	return decodeCacheFile(&gamePath, p)
+}
+
+// parseScriptedLocChoices reads GetName=LOC_KEY lines picked by user for scripted localisation.
+func parseScriptedLocChoices(s string) (map[string]string, error) {
+	choices := make(map[string]string)
+	for _, line := range strings.Fields(s) {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, errors.New("Wrong scripted localisation text \"" + line + "\", GetName=LOC_KEY expected")
+		}
+		choices[kv[0]] = kv[1]
+	}
+	return choices, nil
+}
+
+// saveScriptedLocChoices saves scripted localisation picked by user for the following runs.
+func saveScriptedLocChoices() error {
+	var lines []string
+	for name, key := range scriptedLocChoices {
+		lines = append(lines, name+"="+key)
+	}
+	sort.Strings(lines)
+	return ioutil.WriteFile(filepath.Join(binPath, scriptedLocFile), []byte(strings.Join(lines, "\n")), 0644)
+}
+
+// loadSavedScriptedLocChoices reads scripted localisation picked by user during previous runs.
+func loadSavedScriptedLocChoices() error {
+	b, err := ioutil.ReadFile(filepath.Join(binPath, scriptedLocFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	scriptedLocChoices, err = parseScriptedLocChoices(string(b))
+	if err != nil {
+		scriptedLocChoices = make(map[string]string)
+		return fmt.Errorf("%v: %v", scriptedLocFile, err)
+	}
+	return nil
+}
+
+// printWarnings prints warnings of the set in sorted order.
+func printWarnings(set map[string]bool) {
+	warnings := make([]string, 0, len(set))
+	for w := range set {
+		warnings = append(warnings, w)
+	}
+	sort.Strings(warnings)
+	for _, w := range warnings {
+		ansi.Println("\x1b[33m" + w + "\x1b[0m")
+	}
+}
+
 func stringContainsSlice(s string, slice []string) bool {
 	for _, substr := range slice {
 		c := strings.Contains(s, substr)
@@ -404,33 +742,45 @@ func replaceFontPathsIfNotFound() {
 	}
 }
 
-func initFont(fontName string) (bmfonter.Font, error) {
-	var font bmfonter.Font
+func initFont(fontName string) (*textFont, error) {
+	// Language override is used if the font has one for the current language.
+	if _, ok := fontMap[languageFontName(fontName, language)]; ok {
+		fontName = languageFontName(fontName, language)
+	}
+	if f, ok := textFonts[fontName]; ok {
+		return f, nil
+	}
 	bmfont, ok := fontMap[fontName]
 	if !ok {
-		return font, fmt.Errorf("font \"" + fontName + "\" not found")
+		return nil, fmt.Errorf("font \"" + fontName + "\" not found")
 	}
 
 	if len(bmfont.Fontfiles) < 1 {
-		return font, fmt.Errorf("font \"" + fontName + "\" has no associated files")
+		return nil, fmt.Errorf("font \"" + fontName + "\" has no associated files")
 	}
 
-	// Init font.
-	font, err := bmfonter.InitFont(bmfont.Fontfiles[0]+".fnt", bmfont.Fontfiles[0]+".dds")
+	// Init font, glyphs missing from the first font file are taken from the following ones.
+	font, err := loadTextFont(bmfont.Fontfiles[0])
 	if err != nil {
-		return font, err
+		return nil, err
 	}
-
-	if len(bmfont.Fontfiles) > 1 {
-		for i := 1; i < len(bmfont.Fontfiles); i++ {
-			err = font.AddSubFont(bmfont.Fontfiles[i]+".fnt", bmfont.Fontfiles[i]+".dds")
-			return font, err
+	for _, path := range bmfont.Fontfiles[1:] {
+		sub, err := loadTextFont(path)
+		if err != nil {
+			return nil, err
 		}
+		font.addSubFont(sub)
 	}
 
+	textFonts[fontName] = font
 	return font, nil
 }
 
+// languageFontName returns the fontMap key of the font override for the language.
+func languageFontName(fontName, language string) string {
+	return fontName + "@" + language
+}
+
 func showError(err error) {
 	ansi.Println("\x1b[31;1m" + err.Error() + "\x1b[0m")
 
@@ -454,6 +804,66 @@ func showError(err error) {
 	return
 }
 
+// modFiles returns files with given extension from dir of every mod path in load order.
+// Files of each mod path go after the files of the earlier ones, sorted by path.
+// A file replaces the file with the same relative path from the earlier mod paths
+// and takes its place in the order of the replacing mod, so it is merged after the files of the mods in between.
+func modFiles(dir, ext string) ([]string, error) {
+	var relPaths []string
+	owners := make(map[string]string)
+	for _, modPath := range modPaths {
+		root := filepath.Join(modPath, dir)
+		if _, err := os.Stat(root); err != nil {
+			continue
+		}
+		files, err := WalkMatchExt(root, ext)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			rel := strings.ToLower(strings.TrimPrefix(f, modPath))
+			if _, ok := owners[rel]; ok {
+				relPaths = removeString(relPaths, rel)
+			}
+			relPaths = append(relPaths, rel)
+			owners[rel] = f
+		}
+	}
+
+	files := make([]string, 0, len(relPaths))
+	for _, rel := range relPaths {
+		files = append(files, owners[rel])
+	}
+	return files, nil
+}
+
+// relPath returns the path of the file inside dir, in lower case as the game does not care about it.
+// Files with the same relative path in different mod folders replace each other.
+func relPath(dir, path string) string {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return path
+	}
+	return strings.ToLower(filepath.ToSlash(rel))
+}
+
+// isInDir reports if path is inside dir.
+// Paths are compared by their elements, so "game_mods/a" is not inside "game".
+func isInDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func removeString(s []string, a string) []string {
+	for i, b := range s {
+		if a == b {
+			return append(s[:i], s[i+1:]...)
+		}
+	}
+	return s
+}
+
 func WalkMatchExt(root, ext string) ([]string, error) {
 	var match []string
 
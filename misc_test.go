@@ -0,0 +1,286 @@
+package main
+
+import (
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFillAbsoluteFocusPositions(t *testing.T) {
+	tests := []struct {
+		name    string
+		focuses []Focus
+		want    map[string][2]int
+		err     string
+	}{
+		{
+			name: "chain",
+			focuses: []Focus{
+				{ID: "A", X: 1, Y: 1},
+				{ID: "B", X: 2, Y: 1, RelativePositionID: "A"},
+				{ID: "C", X: -1, Y: 1, RelativePositionID: "B"},
+			},
+			want: map[string][2]int{"A": {1, 1}, "B": {3, 2}, "C": {2, 3}},
+		},
+		{
+			name: "cycle",
+			focuses: []Focus{
+				{ID: "A", RelativePositionID: "B"},
+				{ID: "B", RelativePositionID: "A"},
+			},
+			err: "relative_position_id cycle: A -> B -> A",
+		},
+		{
+			name: "self reference",
+			focuses: []Focus{
+				{ID: "A", RelativePositionID: "A"},
+			},
+			err: "relative_position_id cycle: A -> A",
+		},
+		{
+			name: "missing focus",
+			focuses: []Focus{
+				{ID: "A", RelativePositionID: "B"},
+			},
+			err: `focus "A" has relative_position_id "B" that is not found`,
+		},
+	}
+
+	defer func() { focusMap = make(map[string]Focus) }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			focusMap = make(map[string]Focus)
+			for _, f := range tt.focuses {
+				focusMap[f.ID] = f
+			}
+
+			err := fillAbsoluteFocusPositions()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for id, p := range tt.want {
+				f := focusMap[id]
+				if f.X != p[0] || f.Y != p[1] {
+					t.Errorf("focus %v at %v, %v, want %v, %v", id, f.X, f.Y, p[0], p[1])
+				}
+			}
+		})
+	}
+}
+
+func TestPathDays(t *testing.T) {
+	focusMap = map[string]Focus{
+		"A": {ID: "A", Cost: 10},
+		"B": {ID: "B", Cost: 5, Prerequisite: [][]string{{"A"}}},
+		"C": {ID: "C", Cost: 1.5, Prerequisite: [][]string{{"X", "B"}}},
+	}
+	defer func() { focusMap = make(map[string]Focus) }()
+
+	tests := []struct {
+		name string
+		ids  []string
+		days int
+		err  string
+	}{
+		{"single focus", []string{"A"}, 70, ""},
+		{"path", []string{"A", "B", "C"}, 70 + 35 + 11, ""},
+		{"missing focus", []string{"A", "D"}, 0, `focus id "D" not found`},
+		{"not a prerequisite", []string{"A", "C"}, 0, `focus "A" is not a prerequisite of "C"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			days, err := pathDays(tt.ids)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if days != tt.days {
+				t.Errorf("got %v days, want %v", days, tt.days)
+			}
+		})
+	}
+}
+
+func TestIsFocusShown(t *testing.T) {
+	defer func() {
+		searchFilterMode = SearchFilterOff
+		selectedSearchFilters = nil
+	}()
+	selectedSearchFilters = []string{"FOCUS_FILTER_POLITICAL"}
+
+	tests := []struct {
+		name  string
+		mode  string
+		focus Focus
+		want  bool
+	}{
+		{"not allowed branch", SearchFilterOff, Focus{}, false},
+		{"no filters selected", SearchFilterOff, Focus{AllowBranch: true}, true},
+		{"dimmed focus", SearchFilterDim, Focus{AllowBranch: true}, true},
+		{"hidden focus", SearchFilterHide, Focus{AllowBranch: true, SearchFilters: []string{"FOCUS_FILTER_INDUSTRY"}}, false},
+		{"focus with selected filter", SearchFilterHide, Focus{AllowBranch: true, SearchFilters: []string{"FOCUS_FILTER_POLITICAL"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			searchFilterMode = tt.mode
+			if got := isFocusShown(tt.focus); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFocusTreePoint(t *testing.T) {
+	focusMap = map[string]Focus{
+		"A": {ID: "A", X: -2, Y: 0, AllowBranch: true},
+		"B": {ID: "B", X: 1, Y: -1, AllowBranch: true},
+	}
+	gui.FocusSpacing = image.Point{96, 130}
+	defer func() {
+		focusMap = make(map[string]Focus)
+		gui = FocusGUI{}
+		focusTreeShift = image.Point{}
+	}()
+
+	moveAbsoluteFocusPositionsToPositiveValues()
+	want := image.Point{2*96 + spacingX + 10, 130 + spacingY + 20}
+	if got := focusTreePoint(image.Point{10, 20}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Positions of focuses are converted the same way they are drawn.
+	a := focusMap["A"]
+	want = image.Point{a.X*96 + spacingX, a.Y*130 + spacingY}
+	if got := focusTreePoint(image.Point{-2 * 96, 0}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestScriptedLocChoices(t *testing.T) {
+	got, err := parseScriptedLocChoices("GetName=NAME_A\n GetTitle=TITLE_B \n\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"GetName": "NAME_A", "GetTitle": "TITLE_B"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, s := range []string{"GetName", "GetName=", "=NAME_A"} {
+		if _, err := parseScriptedLocChoices(s); err == nil {
+			t.Errorf("%q: error expected", s)
+		}
+	}
+
+	// Choices are saved next to the binary and read back on the next run.
+	binPath = t.TempDir()
+	defer func() {
+		binPath = ""
+		scriptedLocChoices = make(map[string]string)
+	}()
+	scriptedLocChoices = make(map[string]string)
+	err = loadSavedScriptedLocChoices()
+	if err != nil || len(scriptedLocChoices) != 0 {
+		t.Fatalf("missing file: got %v, %v", scriptedLocChoices, err)
+	}
+	scriptedLocChoices = want
+	err = saveScriptedLocChoices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scriptedLocChoices = nil
+	err = loadSavedScriptedLocChoices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(scriptedLocChoices, want) {
+		t.Errorf("got %v, want %v", scriptedLocChoices, want)
+	}
+}
+
+func TestOwnedDLCsFile(t *testing.T) {
+	binPath = t.TempDir()
+	defer func() {
+		binPath = ""
+		ownedDLCs = nil
+	}()
+
+	// Every DLC is owned until they are picked.
+	ownedDLCs = nil
+	err := loadSavedOwnedDLCs()
+	if err != nil || ownedDLCs != nil {
+		t.Fatalf("missing file: got %v, %v", ownedDLCs, err)
+	}
+
+	for _, want := range []map[string]bool{
+		{"Waking the Tiger": true, "La Resistance": true},
+		{},
+	} {
+		ownedDLCs = want
+		err = saveOwnedDLCs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ownedDLCs = nil
+		err = loadSavedOwnedDLCs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ownedDLCs, want) {
+			t.Errorf("got %v, want %v", ownedDLCs, want)
+		}
+	}
+}
+
+func TestParseDaysPerCost(t *testing.T) {
+	tests := []struct {
+		s    string
+		days float64
+		ok   bool
+	}{
+		{"", defaultDaysPerCost, true},
+		{"  ", defaultDaysPerCost, true},
+		{"3.5", 3.5, true},
+		{"0", 0, false},
+		{"-7", 0, false},
+		{"seven", 0, false},
+		{"+Inf", 0, false},
+	}
+	for _, tt := range tests {
+		days, err := parseDaysPerCost(tt.s)
+		if days != tt.days || (err == nil) != tt.ok {
+			t.Errorf("parseDaysPerCost(%q) = %v, %v, want %v, ok %v", tt.s, days, err, tt.days, tt.ok)
+		}
+	}
+}
+
+func TestPathDurations(t *testing.T) {
+	focusMap = map[string]Focus{
+		"A": {ID: "A", Cost: 10},
+		"B": {ID: "B", Cost: 5, Prerequisite: [][]string{{"A"}}},
+	}
+	focusPaths = [][]string{{"A", "B"}, {"B", "A"}}
+	defer func() {
+		focusMap = make(map[string]Focus)
+		focusPaths = nil
+	}()
+
+	lines, errs := pathDurations()
+	if want := []string{"A (70 d) -> B (35 d) = 105 days"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("got %q, want %q", lines, want)
+	}
+	if len(errs) != 1 || errs[0].Error() != `focus "B" is not a prerequisite of "A"` {
+		t.Errorf("got errors %v", errs)
+	}
+}
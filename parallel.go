@@ -0,0 +1,81 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/macroblock/imed/pkg/ptool"
+)
+
+// parseWorkers is the number of files parsed at the same time.
+var parseWorkers = runtime.NumCPU()
+
+// parserPool keeps parsers built from the rule, so every worker has its own one.
+type parserPool struct {
+	rule string
+	mu   sync.Mutex
+	free []*ptool.TParser
+}
+
+var pdxParsers = &parserPool{rule: pdxRule}
+var ymlParsers = &parserPool{rule: ymlRule}
+
+func (p *parserPool) get() (*ptool.TParser, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.free) == 0 {
+		return ptool.NewBuilder().FromString(p.rule).Entries("entry").Build()
+	}
+	parser := p.free[len(p.free)-1]
+	p.free = p.free[:len(p.free)-1]
+	return parser, nil
+}
+
+func (p *parserPool) put(parser *ptool.TParser) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.free = append(p.free, parser)
+}
+
+// parseConcurrently parses files with a bounded pool of workers.
+// Results are returned in the order of files, so they can be merged in load order.
+// The first error in the order of files is returned.
+func parseConcurrently(files []string, parsers *parserPool, parse func(parser *ptool.TParser, i int) (ParsedFile, error)) ([]ParsedFile, error) {
+	results := make([]ParsedFile, len(files))
+	errs := make([]error, len(files))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parseWorkers && w < len(files); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			parser, err := parsers.get()
+			if err != nil {
+				for i := range jobs {
+					errs[i] = err
+				}
+				return
+			}
+			defer parsers.put(parser)
+
+			for i := range jobs {
+				results[i], errs[i] = parse(parser, i)
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
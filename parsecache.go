@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/k0kubun/go-ansi"
+)
+
+// parseCacheVersion must be increased when cached types are changed.
+const parseCacheVersion = 5
+
+// ParseCache holds definitions parsed from gfx and localisation files and name indexes between runs.
+type ParseCache struct {
+	Version  int
+	Files    map[string]ParsedFile
+	GFXIndex map[string]*nameIndex
+	LocIndex map[string]*nameIndex
+}
+
+// ParsedFile holds definitions of a single file.
+// Localisation files of other languages are cached with their language only.
+type ParsedFile struct {
+	Size       int64
+	ModTime    time.Time
+	Hash       [sha256.Size]byte
+	Lenient    bool
+	Parsed     bool
+	Language   string
+	Sprites    []SpriteType
+	Fonts      []BitmapFont
+	TextColors map[string]color.NRGBA
+	Loc        map[string][]Localisation
+}
+
+var parseCache *ParseCache
+var isParseCacheChanged bool
+
+// parseCacheMu guards parse cache, files are parsed concurrently.
+var parseCacheMu sync.Mutex
+
+func parseCachePath() string {
+	return filepath.Join(binPath, "hoi4treesnapParseCache.gob")
+}
+
+// loadParseCache reads parse cache from the disk once per session.
+// Broken or outdated cache is replaced with an empty one.
+func loadParseCache() {
+	if parseCache != nil {
+		return
+	}
+	parseCache = &ParseCache{Version: parseCacheVersion, Files: make(map[string]ParsedFile)}
+
+	b, err := ioutil.ReadFile(parseCachePath())
+	if err != nil {
+		return
+	}
+	var c ParseCache
+	err = gob.NewDecoder(bytes.NewReader(b)).Decode(&c)
+	if err != nil || c.Version != parseCacheVersion || c.Files == nil {
+		ansi.Println("\x1b[33m" + "Parse cache is outdated or broken, files will be parsed again" + "\x1b[0m")
+		return
+	}
+	parseCache = &c
+	if c.GFXIndex != nil {
+		gfxIndex = c.GFXIndex
+	}
+	if c.LocIndex != nil {
+		locIndex = c.LocIndex
+	}
+}
+
+// saveParseCache writes parse cache to the disk if it was changed.
+// Each write uses a new encoder, so the file always contains type information.
+func saveParseCache() error {
+	if parseCache == nil || !isParseCacheChanged {
+		return nil
+	}
+	parseCache.GFXIndex = gfxIndex
+	parseCache.LocIndex = locIndex
+
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(parseCache)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(parseCachePath(), b.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	isParseCacheChanged = false
+	return nil
+}
+
+// cachedParse returns definitions of the file from parse cache.
+// File is parsed again if its size, modification time and content hash changed,
+// or if it was parsed in lenient mode that is now off.
+func cachedParse(path string, parse func(f, path string) (ParsedFile, error)) (ParsedFile, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ParsedFile{}, err
+	}
+	parseCacheMu.Lock()
+	loadParseCache()
+	p, ok := parseCache.Files[path]
+	parseCacheMu.Unlock()
+	ok = ok && (!p.Lenient || isLenientParsing) && (p.Parsed || p.Language != language)
+	if ok && p.Size == fi.Size() && p.ModTime.Equal(fi.ModTime()) {
+		return p, nil
+	}
+
+	f, err := readFile(path)
+	if err != nil {
+		return ParsedFile{}, err
+	}
+	hash := sha256.Sum256([]byte(f))
+	if !ok || p.Hash != hash {
+		p, err = parse(f, path)
+		if err != nil {
+			return ParsedFile{}, err
+		}
+		p.Hash = hash
+		p.Lenient = isLenientParsing
+	}
+	p.Size, p.ModTime = fi.Size(), fi.ModTime()
+	parseCacheMu.Lock()
+	parseCache.Files[path] = p
+	isParseCacheChanged = true
+	parseCacheMu.Unlock()
+	return p, nil
+}
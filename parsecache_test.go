@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCachedParse(t *testing.T) {
+	binPath = t.TempDir()
+	parseCache = nil
+	defer func() {
+		parseCache = nil
+		isParseCacheChanged = false
+		isLenientParsing = false
+		language = "l_english"
+		binPath = ""
+	}()
+
+	path := filepath.Join(t.TempDir(), "test.yml")
+	write := func(s string, modTime time.Time) {
+		err := os.WriteFile(path, []byte(s), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(path, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Parse function keeps the contents, files of other languages are not parsed like in parseLocFile.
+	var parsed int
+	parse := func(f, path string) (ParsedFile, error) {
+		parsed++
+		p := ParsedFile{Language: "l_english"}
+		if language == p.Language {
+			p.Parsed = true
+			p.Loc = map[string][]Localisation{p.Language: {{Key: "KEY", Value: f}}}
+		}
+		return p, nil
+	}
+
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		f       string
+		modTime time.Time
+		lenient bool
+		lang    string
+		parsed  bool
+	}{
+		{"new file", "a", modTime, false, "l_english", true},
+		{"unchanged file", "a", modTime, false, "l_english", false},
+		{"touched file with the same contents", "a", modTime.Add(1 * time.Hour), false, "l_english", false},
+		{"changed file", "bb", modTime.Add(2 * time.Hour), false, "l_english", true},
+		{"changed file with the same size and time is not checked", "cc", modTime.Add(2 * time.Hour), false, "l_english", false},
+		{"file of other language", "dd", modTime.Add(3 * time.Hour), false, "l_russian", true},
+		{"file of other language is parsed when it is selected", "dd", modTime.Add(3 * time.Hour), false, "l_english", true},
+		{"lenient mode", "ee", modTime.Add(4 * time.Hour), true, "l_english", true},
+		{"lenient parsed file is parsed again when lenient mode is off", "ee", modTime.Add(4 * time.Hour), false, "l_english", true},
+		{"strict parsed file is used in lenient mode", "ee", modTime.Add(4 * time.Hour), true, "l_english", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			write(tt.f, tt.modTime)
+			isLenientParsing = tt.lenient
+			language = tt.lang
+			parsed = 0
+
+			p, err := cachedParse(path, parse)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (parsed > 0) != tt.parsed {
+				t.Errorf("got parsed %v, want %v", parsed > 0, tt.parsed)
+			}
+			if p.Lenient != (tt.lenient && tt.parsed) {
+				t.Errorf("got lenient %v", p.Lenient)
+			}
+			if !p.ModTime.Equal(tt.modTime) {
+				t.Errorf("got modification time %v, want %v", p.ModTime, tt.modTime)
+			}
+		})
+	}
+}
+
+func TestParseCacheSaveLoad(t *testing.T) {
+	binPath = t.TempDir()
+	parseCache = nil
+	defer func() {
+		parseCache = nil
+		isParseCacheChanged = false
+		binPath = ""
+	}()
+
+	path := filepath.Join(t.TempDir(), "test.gfx")
+	err := os.WriteFile(path, []byte("spriteTypes = {}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parse := func(f, path string) (ParsedFile, error) {
+		return ParsedFile{Parsed: true, Sprites: []SpriteType{{Name: "GFX_test"}}}, nil
+	}
+	_, err = cachedParse(path, parse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = saveParseCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cache is read from the disk by the next session.
+	parseCache = nil
+	p, err := cachedParse(path, func(f, path string) (ParsedFile, error) {
+		t.Error("cached file is parsed again")
+		return ParsedFile{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Sprites) != 1 || p.Sprites[0].Name != "GFX_test" {
+		t.Errorf("got sprites %v", p.Sprites)
+	}
+
+	// Outdated cache is dropped.
+	parseCache.Version = parseCacheVersion - 1
+	isParseCacheChanged = true
+	err = saveParseCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parseCache = nil
+	loadParseCache()
+	if len(parseCache.Files) != 0 {
+		t.Error("outdated cache is loaded")
+	}
+}
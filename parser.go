@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"fmt"
 	"image"
+	"image/color"
 	"math"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 
+	"github.com/k0kubun/go-ansi"
 	"github.com/macroblock/imed/pkg/ptool"
 )
 
@@ -72,6 +74,17 @@ var ymlRule = `
 	empty                = '';
 `
 
+// buildParsers builds pdx and yml parsers used to read node types.
+func buildParsers() error {
+	var err error
+	pdx, err = ptool.NewBuilder().FromString(pdxRule).Entries("entry").Build()
+	if err != nil {
+		return err
+	}
+	yml, err = ptool.NewBuilder().FromString(ymlRule).Entries("entry").Build()
+	return err
+}
+
 func parseFocus(path string) error {
 	fmt.Println(path)
 	f, err := readFile(path)
@@ -85,7 +98,7 @@ func parseFocus(path string) error {
 			f = string(bytes.TrimPrefix([]byte(f), utf8bom))
 		}
 
-		node, err := pdx.Parse(f)
+		node, err := parsePDX(pdx, f, path)
 		if err != nil {
 			return err
 		}
@@ -109,6 +122,7 @@ func traverseFocus(root *ptool.TNode) error {
 				var f Focus
 				f.AllowBranch = true
 				f.Available = true
+				f.Cost = 10
 				var err error
 				var n float64
 				for _, link := range node.Links {
@@ -139,6 +153,12 @@ func traverseFocus(root *ptool.TNode) error {
 							f.Y = int(math.Trunc(n))
 						case "relative_position_id":
 							f.RelativePositionID = link.Links[1].Value
+						case "cost":
+							// Cost can be a scripted constant, game default is kept in that case.
+							n, err = strconv.ParseFloat(link.Links[1].Value, 64)
+							if err == nil {
+								f.Cost = n
+							}
 						}
 					case "declrScope":
 						switch strings.ToLower(link.Links[0].Value) {
@@ -166,37 +186,26 @@ func traverseFocus(root *ptool.TNode) error {
 									}
 								}
 							}
-						case "allow_branch":
+						case "search_filters":
 							for _, link := range link.Links {
 								nodeType := pdx.ByID(link.Type)
 								switch nodeType {
-								case "declr":
-									switch strings.ToLower(link.Links[0].Value) {
-									case "always":
-										if strings.ToLower(link.Links[1].Value) == "no" {
-											f.AllowBranch = false
-										}
-									case "has_country_flag":
-										if strings.ToLower(link.Links[1].Value) == "romanov_enabled" { // Poland tree workaround
-											f.AllowBranch = false
-										}
-									}
-								case "declrScope":
-									switch strings.ToLower(link.Links[0].Value) {
-									case "not":
-										for _, link := range link.Links {
-											nodeType := pdx.ByID(link.Type)
-											switch nodeType {
-											case "declr":
-												switch strings.ToLower(link.Links[0].Value) {
-												case "has_dlc":
-													f.AllowBranch = false
-												}
-											}
+								case "list":
+									for _, link := range link.Links {
+										nodeType := pdx.ByID(link.Type)
+										switch nodeType {
+										case "anyType":
+											filter := trimQuotes(link.Value)
+											f.SearchFilters = append(f.SearchFilters, filter)
+											gfxList = append(gfxList, "\""+searchFilterSpriteName(filter)+"\"")
 										}
 									}
 								}
 							}
+						case "allow_branch":
+							if allow, ok := evalTrigger(link, "and", ""); ok {
+								f.AllowBranch = allow
+							}
 						case "available":
 							for _, link := range link.Links {
 								if len(link.Links) > 0 {
@@ -207,6 +216,56 @@ func traverseFocus(root *ptool.TNode) error {
 					}
 				}
 				focusMap[f.ID] = f
+			case "focus_tree":
+				var err error
+				for _, link := range node.Links {
+					nodeType := pdx.ByID(link.Type)
+					switch nodeType {
+					case "declr":
+						switch strings.ToLower(link.Links[0].Value) {
+						case "id":
+							focusTree.ID = link.Links[1].Value
+						}
+					case "declrScope":
+						switch strings.ToLower(link.Links[0].Value) {
+						case "country":
+							focusTree.Tag = countryTag(link)
+						case "continuous_focus_position":
+							var pos image.Point
+							pos, err = parsePosition(link)
+							if err != nil {
+								return err
+							}
+							focusTree.ContinuousFocusPosition = pos
+							focusTree.HasContinuousFocusPosition = true
+						case "inlay_window":
+							var w InlayWindowRef
+							for _, link := range link.Links {
+								nodeType := pdx.ByID(link.Type)
+								switch nodeType {
+								case "declr":
+									switch strings.ToLower(link.Links[0].Value) {
+									case "id":
+										w.ID = link.Links[1].Value
+									}
+								case "declrScope":
+									switch strings.ToLower(link.Links[0].Value) {
+									case "position":
+										w.Position, err = parsePosition(link)
+										if err != nil {
+											return err
+										}
+									}
+								}
+							}
+							focusTree.InlayWindows = append(focusTree.InlayWindows, w)
+						}
+					}
+				}
+				err = traverseFocus(node)
+				if err != nil {
+					return err
+				}
 			default:
 				err := traverseFocus(node)
 				if err != nil {
@@ -218,621 +277,217 @@ func traverseFocus(root *ptool.TNode) error {
 	return nil
 }
 
-func parseGUI(path string) error {
-	fPath := filepath.Join(path, "interface", "nationalfocusview.gui")
-	fmt.Println(fPath)
-
-	f, err := readFile(fPath)
+// focusTitleKeys returns localisation keys of the focus titles in the focus tree file without changing the parsed focus tree.
+func focusTitleKeys(path string) ([]string, error) {
+	f, err := readFile(path)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	// Remove utf-8 bom if found.
+	if bytes.HasPrefix([]byte(f), utf8bom) {
+		f = string(bytes.TrimPrefix([]byte(f), utf8bom))
+	}
+	if len(f) == 0 {
+		return nil, nil
 	}
 
-	if len(f) > 0 {
-		// Remove utf-8 bom if found.
-		if bytes.HasPrefix([]byte(f), utf8bom) {
-			f = string(bytes.TrimPrefix([]byte(f), utf8bom))
-		}
+	node, err := parsePDX(pdx, f, path)
+	if err != nil {
+		return nil, err
+	}
+	return traverseFocusTitleKeys(node), nil
+}
 
-		node, err := pdx.Parse(f)
-		if err != nil {
-			return err
+// traverseFocusIDs returns ids of the focuses.
+func traverseFocusIDs(root *ptool.TNode) []string {
+	var ids []string
+	for _, node := range root.Links {
+		if pdx.ByID(node.Type) != "declrScope" {
+			continue
 		}
-		_ = node
-		// fmt.Println(ptool.TreeToString(node, pdx.ByID))
-		err = traverseGUI(node)
-		if err != nil {
-			return err
+		switch strings.ToLower(node.Links[0].Value) {
+		case "focus", "shared_focus":
+			for _, link := range node.Links {
+				if pdx.ByID(link.Type) == "declr" && strings.ToLower(link.Links[0].Value) == "id" {
+					ids = append(ids, link.Links[1].Value)
+				}
+			}
+		default:
+			ids = append(ids, traverseFocusIDs(node)...)
 		}
 	}
-	return nil
+	return ids
 }
 
-func traverseGUI(root *ptool.TNode) error {
-	var err error
+// traverseFocusTitleKeys returns title keys of the focuses, the text key if it is set and the focus id otherwise.
+// Descriptions are not drawn, so their keys are not included.
+func traverseFocusTitleKeys(root *ptool.TNode) []string {
+	var keys []string
 	for _, node := range root.Links {
-		nodeType := pdx.ByID(node.Type)
-		switch nodeType {
-		case "declrScope":
-			switch strings.ToLower(node.Links[0].Value) {
-			case "containerwindowtype":
-				nfv := false
-				nfi := false
-				nfl := false
-				nfei := false
-				for _, link := range node.Links {
-					if pdx.ByID(link.Type) == "declr" {
-						if strings.ToLower(link.Links[0].Value) == "name" && link.Links[1].Value == "nationalfocusview" {
-							nfv = true
-						}
-						if strings.ToLower(link.Links[0].Value) == "name" && link.Links[1].Value == "national_focus_item" {
-							nfi = true
-						}
-						if strings.ToLower(link.Links[0].Value) == "name" && link.Links[1].Value == "national_focus_link" {
-							nfl = true
-						}
-						if strings.ToLower(link.Links[0].Value) == "name" && link.Links[1].Value == "national_focus_exclusive_item" {
-							nfei = true
-						}
-					}
+		if pdx.ByID(node.Type) != "declrScope" {
+			continue
+		}
+		switch strings.ToLower(node.Links[0].Value) {
+		case "focus", "shared_focus":
+			var id, text string
+			for _, link := range node.Links {
+				if pdx.ByID(link.Type) != "declr" {
+					continue
 				}
-
-				switch {
-				case nfv:
-					for _, link := range node.Links {
-						if len(link.Links) > 0 {
-							switch strings.ToLower(link.Links[0].Value) {
-							case "instanttextboxtype":
-								var t InstantTextboxType
-								for _, link := range link.Links {
-									if len(link.Links) > 0 {
-										switch strings.ToLower(link.Links[0].Value) {
-										case "name":
-											t.Name = link.Links[1].Value
-										case "position":
-											for _, link := range link.Links {
-												nodeType := pdx.ByID(link.Type)
-												switch nodeType {
-												case "declr":
-													switch strings.ToLower(link.Links[0].Value) {
-													case "x":
-														t.Position.X, err = strconv.Atoi(link.Links[1].Value)
-														if err != nil {
-															return err
-														}
-													case "y":
-														t.Position.Y, err = strconv.Atoi(link.Links[1].Value)
-														if err != nil {
-															return err
-														}
-													}
-												}
-											}
-										case "font":
-											t.Font = link.Links[1].Value
-											gfxList = append(gfxList, "\""+link.Links[1].Value+"\"")
-										case "text":
-											t.Text = link.Links[1].Value
-										case "maxwidth":
-											t.MaxWidth, err = strconv.Atoi(link.Links[1].Value)
-											if err != nil {
-												return err
-											}
-										case "maxheight":
-											t.MaxHeight, err = strconv.Atoi(link.Links[1].Value)
-											if err != nil {
-												return err
-											}
-										case "format":
-											t.Format = link.Links[1].Value
-										case "vertical_alignment":
-											t.VerticalAlignment = link.Links[1].Value
-										}
-									}
-								}
-								if t.Name == "national_focus_title" {
-									gui.NationalFocusTitle = t
-								}
-							}
-						}
-					}
-
-				case nfi:
-					for _, link := range node.Links {
-						if len(link.Links) > 0 {
-							switch strings.ToLower(link.Links[0].Value) {
-							case "name":
-								gui.NationalFocusItem.Name = link.Links[1].Value
-							case "position":
-								for _, link := range link.Links {
-									nodeType := pdx.ByID(link.Type)
-									switch nodeType {
-									case "declr":
-										switch strings.ToLower(link.Links[0].Value) {
-										case "x":
-											gui.NationalFocusItem.Position.X, err = strconv.Atoi(link.Links[1].Value)
-											if err != nil {
-												return err
-											}
-										case "y":
-											gui.NationalFocusItem.Position.Y, err = strconv.Atoi(link.Links[1].Value)
-											if err != nil {
-												return err
-											}
-										}
-									}
-								}
-							case "size":
-								for _, link := range link.Links {
-									nodeType := pdx.ByID(link.Type)
-									switch nodeType {
-									case "declr":
-										switch strings.ToLower(link.Links[0].Value) {
-										case "width":
-											gui.NationalFocusItem.Width, err = strconv.Atoi(link.Links[1].Value)
-											if err != nil {
-												return err
-											}
-										case "height":
-											gui.NationalFocusItem.Height, err = strconv.Atoi(link.Links[1].Value)
-											if err != nil {
-												return err
-											}
-										}
-									}
-								}
-							case "buttontype":
-								var button ButtonType
-								for _, link := range link.Links {
-									if len(link.Links) > 0 {
-										switch strings.ToLower(link.Links[0].Value) {
-										case "name":
-											button.Name = link.Links[1].Value
-										case "position":
-											for _, link := range link.Links {
-												nodeType := pdx.ByID(link.Type)
-												switch nodeType {
-												case "declr":
-													switch strings.ToLower(link.Links[0].Value) {
-													case "x":
-														button.Position.X, err = strconv.Atoi(link.Links[1].Value)
-														if err != nil {
-															return err
-														}
-													case "y":
-														button.Position.Y, err = strconv.Atoi(link.Links[1].Value)
-														if err != nil {
-															return err
-														}
-													}
-												}
-											}
-										case "spritetype":
-											button.SpriteType = link.Links[1].Value
-											gfxList = append(gfxList, "\""+link.Links[1].Value+"\"")
-										case "quadtexturesprite":
-											button.SpriteType = link.Links[1].Value
-											gfxList = append(gfxList, "\""+link.Links[1].Value+"\"")
-										case "centerposition":
-											button.CenterPosition = link.Links[1].Value
-										case "orientation":
-											button.Orientation = link.Links[1].Value
-										}
-									}
-								}
-								switch strings.ToLower(button.Name) {
-								case "bg":
-									gui.BG = button
-								case "symbol":
-									gui.Symbol = button
-								}
-							case "instanttextboxtype":
-								name := false
-								for _, link := range link.Links {
-									if pdx.ByID(link.Type) == "declr" {
-										if strings.ToLower(link.Links[0].Value) == "name" && link.Links[1].Value == "name" {
-											name = true
-										}
-									}
-								}
-								if name {
-									for _, link := range link.Links {
-										if len(link.Links) > 0 {
-											switch strings.ToLower(link.Links[0].Value) {
-											case "name":
-												gui.Name.Name = link.Links[1].Value
-											case "position":
-												for _, link := range link.Links {
-													nodeType := pdx.ByID(link.Type)
-													switch nodeType {
-													case "declr":
-														switch strings.ToLower(link.Links[0].Value) {
-														case "x":
-															gui.Name.Position.X, err = strconv.Atoi(link.Links[1].Value)
-															if err != nil {
-																return err
-															}
-														case "y":
-															gui.Name.Position.Y, err = strconv.Atoi(link.Links[1].Value)
-															if err != nil {
-																return err
-															}
-														}
-													}
-												}
-											case "font":
-												gui.Name.Font = link.Links[1].Value
-												gfxList = append(gfxList, "\""+link.Links[1].Value+"\"")
-											case "text":
-												gui.Name.Text = link.Links[1].Value
-											case "maxwidth":
-												gui.Name.MaxWidth, err = strconv.Atoi(link.Links[1].Value)
-												if err != nil {
-													return err
-												}
-											case "maxheight":
-												gui.Name.MaxHeight, err = strconv.Atoi(link.Links[1].Value)
-												if err != nil {
-													return err
-												}
-											case "format":
-												gui.Name.Format = link.Links[1].Value
-											case "vertical_alignment":
-												gui.Name.VerticalAlignment = link.Links[1].Value
-											}
-										}
-									}
-								}
-							}
-						}
-					}
-
-				case nfl:
-					for _, link := range node.Links {
-						if len(link.Links) > 0 {
-							switch strings.ToLower(link.Links[0].Value) {
-							case "name":
-								gui.NationalFocusLink.Name = link.Links[1].Value
-							case "position":
-								for _, link := range link.Links {
-									nodeType := pdx.ByID(link.Type)
-									switch nodeType {
-									case "declr":
-										switch strings.ToLower(link.Links[0].Value) {
-										case "x":
-											gui.NationalFocusLink.Position.X, err = strconv.Atoi(link.Links[1].Value)
-											if err != nil {
-												return err
-											}
-										case "y":
-											gui.NationalFocusLink.Position.Y, err = strconv.Atoi(link.Links[1].Value)
-											if err != nil {
-												return err
-											}
-										}
-									}
-								}
-							case "size":
-								for _, link := range link.Links {
-									nodeType := pdx.ByID(link.Type)
-									switch nodeType {
-									case "declr":
-										switch strings.ToLower(link.Links[0].Value) {
-										case "width":
-											gui.NationalFocusLink.Width, err = strconv.Atoi(link.Links[1].Value)
-											if err != nil {
-												return err
-											}
-										case "height":
-											gui.NationalFocusLink.Height, err = strconv.Atoi(link.Links[1].Value)
-											if err != nil {
-												return err
-											}
-										}
-									}
-								}
-							case "icontype":
-								var icon IconType
-								for _, link := range link.Links {
-									if len(link.Links) > 0 {
-										switch strings.ToLower(link.Links[0].Value) {
-										case "name":
-											icon.Name = link.Links[1].Value
-										case "position":
-											for _, link := range link.Links {
-												nodeType := pdx.ByID(link.Type)
-												switch nodeType {
-												case "declr":
-													switch strings.ToLower(link.Links[0].Value) {
-													case "x":
-														icon.Position.X, err = strconv.Atoi(link.Links[1].Value)
-														if err != nil {
-															return err
-														}
-													case "y":
-														icon.Position.Y, err = strconv.Atoi(link.Links[1].Value)
-														if err != nil {
-															return err
-														}
-													}
-												}
-											}
-										case "spritetype":
-											icon.SpriteType = link.Links[1].Value
-											gfxList = append(gfxList, "\""+link.Links[1].Value+"\"")
-										case "frame":
-											icon.Frame, err = strconv.Atoi(link.Links[1].Value)
-											if err != nil {
-												return err
-											}
-										}
-									}
-								}
-								if strings.ToLower(icon.Name) == "link" {
-									gui.Link = icon
-								}
-							}
-						}
-					}
-
-				case nfei:
-					for _, link := range node.Links {
-						if len(link.Links) > 0 {
-							switch strings.ToLower(link.Links[0].Value) {
-							case "name":
-								gui.NationalFocusExclusiveItem.Name = link.Links[1].Value
-							case "position":
-								for _, link := range link.Links {
-									nodeType := pdx.ByID(link.Type)
-									switch nodeType {
-									case "declr":
-										switch strings.ToLower(link.Links[0].Value) {
-										case "x":
-											gui.NationalFocusExclusiveItem.Position.X, err = strconv.Atoi(link.Links[1].Value)
-											if err != nil {
-												return err
-											}
-										case "y":
-											gui.NationalFocusExclusiveItem.Position.Y, err = strconv.Atoi(link.Links[1].Value)
-											if err != nil {
-												return err
-											}
-										}
-									}
-								}
-							case "size":
-								for _, link := range link.Links {
-									nodeType := pdx.ByID(link.Type)
-									switch nodeType {
-									case "declr":
-										switch strings.ToLower(link.Links[0].Value) {
-										case "width":
-											gui.NationalFocusExclusiveItem.Width, err = strconv.Atoi(link.Links[1].Value)
-											if err != nil {
-												return err
-											}
-										case "height":
-											gui.NationalFocusExclusiveItem.Height, err = strconv.Atoi(link.Links[1].Value)
-											if err != nil {
-												return err
-											}
-										}
-									}
-								}
-							case "icontype":
-								var icon IconType
-								for _, link := range link.Links {
-									if len(link.Links) > 0 {
-										switch strings.ToLower(link.Links[0].Value) {
-										case "name":
-											icon.Name = link.Links[1].Value
-										case "position":
-											for _, link := range link.Links {
-												nodeType := pdx.ByID(link.Type)
-												switch nodeType {
-												case "declr":
-													switch strings.ToLower(link.Links[0].Value) {
-													case "x":
-														icon.Position.X, err = strconv.Atoi(link.Links[1].Value)
-														if err != nil {
-															return err
-														}
-													case "y":
-														icon.Position.Y, err = strconv.Atoi(link.Links[1].Value)
-														if err != nil {
-															return err
-														}
-													}
-												}
-											}
-										case "spritetype":
-											icon.SpriteType = link.Links[1].Value
-											gfxList = append(gfxList, "\""+link.Links[1].Value+"\"")
-										case "frame":
-											icon.Frame, err = strconv.Atoi(link.Links[1].Value)
-											if err != nil {
-												return err
-											}
-										}
-									}
-								}
-								switch strings.ToLower(icon.Name) {
-								case "link1":
-									gui.Link1 = icon
-								case "link2":
-									gui.Link2 = icon
-								case "left":
-									gui.Left = icon
-								case "right":
-									gui.Right = icon
-								case "mid":
-									gui.Mid = icon
-								}
-							}
-						}
-					}
+				switch strings.ToLower(link.Links[0].Value) {
+				case "id":
+					id = link.Links[1].Value
+				case "text":
+					text = link.Links[1].Value
 				}
+			}
+			if text != "" {
+				keys = append(keys, text)
+			} else if id != "" {
+				keys = append(keys, id)
+			}
+		default:
+			keys = append(keys, traverseFocusTitleKeys(node)...)
+		}
+	}
+	return keys
+}
 
-			case "positiontype":
-				var name string
-				var pos image.Point
-				for _, link := range node.Links {
-					if len(link.Links) > 0 {
-						switch strings.ToLower(link.Links[0].Value) {
-						case "name":
-							name = link.Links[1].Value
-						case "position":
-							for _, link := range link.Links {
-								nodeType := pdx.ByID(link.Type)
-								switch nodeType {
-								case "declr":
-									switch strings.ToLower(link.Links[0].Value) {
-									case "x":
-										pos.X, err = strconv.Atoi(link.Links[1].Value)
-										if err != nil {
-											return err
-										}
-									case "y":
-										pos.Y, err = strconv.Atoi(link.Links[1].Value)
-										if err != nil {
-											return err
-										}
-									}
-								}
-							}
-						}
-					}
-				}
-				switch strings.ToLower(name) {
-				case "focus_spacing":
-					gui.FocusSpacing = pos
-				case "link_spacing":
-					gui.LinkSpacing = pos
-				case "link_offsets":
-					gui.LinkOffsets = pos
-				case "link_begin":
-					gui.LinkBegin = pos
-				case "link_end":
-					gui.LinkEnd = pos
-				case "exclusive_offset":
-					gui.ExclusiveOffset = pos
-				case "exclusive_offset_left":
-					gui.ExclusiveOffsetLeft = pos
-				case "exclusive_positioning":
-					gui.ExclusivePositioning = pos
-				}
+// countryTag returns the tag the focus tree is made for from its country block.
+// It is the tag of the first modifier that adds weight to the tree.
+func countryTag(root *ptool.TNode) string {
+	for _, link := range root.Links {
+		if pdx.ByID(link.Type) != "declrScope" || strings.ToLower(link.Links[0].Value) != "modifier" {
+			continue
+		}
+		var tag string
+		var add float64
+		for _, link := range link.Links {
+			if pdx.ByID(link.Type) != "declr" {
+				continue
+			}
+			switch strings.ToLower(link.Links[0].Value) {
+			case "tag", "original_tag":
+				tag = trimQuotes(link.Links[1].Value)
+			case "add":
+				add, _ = strconv.ParseFloat(link.Links[1].Value, 64)
+			}
+		}
+		if tag != "" && add > 0 {
+			return tag
+		}
+	}
+	return ""
+}
 
-			default:
-				err := traverseGUI(node)
+// parsePosition reads x and y values of a position block.
+func parsePosition(root *ptool.TNode) (image.Point, error) {
+	var pos image.Point
+	var n float64
+	var err error
+	for _, link := range root.Links {
+		nodeType := pdx.ByID(link.Type)
+		switch nodeType {
+		case "declr":
+			switch strings.ToLower(link.Links[0].Value) {
+			case "x":
+				n, err = strconv.ParseFloat(link.Links[1].Value, 64)
 				if err != nil {
-					return err
+					return pos, err
+				}
+				pos.X = int(math.Trunc(n))
+			case "y":
+				n, err = strconv.ParseFloat(link.Links[1].Value, 64)
+				if err != nil {
+					return pos, err
 				}
+				pos.Y = int(math.Trunc(n))
 			}
 		}
 	}
-	return nil
+	return pos, nil
 }
 
-func parseGFX(path string, i int) error {
-	gfxFiles, err := WalkMatchExt(filepath.Join(path, "interface"), ".gfx")
+func parseContinuousFocus(path string) error {
+	dir := filepath.Join(path, "common", "continuous_focus")
+	if _, err := os.Stat(dir); err != nil {
+		return nil
+	}
+	files, err := WalkMatchExt(dir, ".txt")
 	if err != nil {
 		return err
 	}
-	for _, fPath := range gfxFiles {
+	for _, fPath := range files {
+		fmt.Println(fPath)
 		f, err := readFile(fPath)
 		if err != nil {
 			return err
 		}
 
-		if stringContainsSlice(f, gfxList) {
-			fmt.Println(fPath)
-			if len(f) > 0 {
-				// Remove utf-8 bom if found.
-				if bytes.HasPrefix([]byte(f), utf8bom) {
-					f = string(bytes.TrimPrefix([]byte(f), utf8bom))
-				}
+		if len(f) > 0 {
+			// Remove utf-8 bom if found.
+			if bytes.HasPrefix([]byte(f), utf8bom) {
+				f = string(bytes.TrimPrefix([]byte(f), utf8bom))
+			}
 
-				node, err := pdx.Parse(f)
-				if err != nil {
-					return err
-				}
-				_ = node
-				// fmt.Println(ptool.TreeToString(node, pdx.ByID))
-				err = traverseGFX(node, path)
-				if err != nil {
-					return err
-				}
+			node, err := parsePDX(pdx, f, fPath)
+			if err != nil {
+				return err
+			}
+			_ = node
+			// fmt.Println(ptool.TreeToString(node, pdx.ByID))
+			err = traverseContinuousFocus(node)
+			if err != nil {
+				return err
 			}
 		}
-		pBar.SetValue(pBar.Value + 0.4/float64(i)/float64(len(gfxFiles)))
 	}
 	return nil
 }
 
-func traverseGFX(root *ptool.TNode, path string) error {
-	var err error
+func traverseContinuousFocus(root *ptool.TNode) error {
 	for _, node := range root.Links {
 		nodeType := pdx.ByID(node.Type)
 		switch nodeType {
 		case "declrScope":
 			switch strings.ToLower(node.Links[0].Value) {
-			case "spritetype", "corneredtilespritetype":
-				var s SpriteType
-				for _, link := range node.Links {
-					nodeType := pdx.ByID(link.Type)
-					switch nodeType {
-					case "declr":
-						switch strings.ToLower(link.Links[0].Value) {
-						case "name":
-							s.Name = link.Links[1].Value
-						case "texturefile":
-							s.TextureFile = filepath.Join(path, link.Links[1].Value)
-						case "noofframes":
-							s.NoOfFrames, err = strconv.Atoi(link.Links[1].Value)
-							if err != nil {
-								return err
-							}
-						}
-					}
-				}
-				gfxMap[s.Name] = s
-			case "bitmapfont":
-				var b BitmapFont
+			case "continuous_focus_palette":
+				var p ContinuousFocusPalette
 				for _, link := range node.Links {
 					nodeType := pdx.ByID(link.Type)
 					switch nodeType {
 					case "declr":
 						switch strings.ToLower(link.Links[0].Value) {
-						case "name":
-							b.Name = link.Links[1].Value
-						case "path":
-							b.Path = filepath.Join(path, link.Links[1].Value)
+						case "id":
+							p.ID = link.Links[1].Value
+						case "default":
+							p.Default = strings.ToLower(link.Links[1].Value) == "yes"
 						}
 					case "declrScope":
 						switch strings.ToLower(link.Links[0].Value) {
-						case "fontfiles":
+						case "focus":
+							var f ContinuousFocus
 							for _, link := range link.Links {
 								nodeType := pdx.ByID(link.Type)
 								switch nodeType {
-								case "list":
-									for _, link := range link.Links {
-										nodeType := pdx.ByID(link.Type)
-										switch nodeType {
-										case "anyType":
-											b.Fontfiles = append(b.Fontfiles, filepath.Join(path, trimQuotes(link.Value)))
-										}
-
+								case "declr":
+									switch strings.ToLower(link.Links[0].Value) {
+									case "id":
+										f.ID = link.Links[1].Value
+										locList = append(locList, link.Links[1].Value)
+									case "icon":
+										f.Icon = link.Links[1].Value
+										gfxList = append(gfxList, "\""+link.Links[1].Value+"\"")
 									}
 								}
 							}
+							p.Focuses = append(p.Focuses, f)
 						}
 					}
 				}
-				if len(b.Fontfiles) < 1 && b.Path != "" {
-					b.Fontfiles = append(b.Fontfiles, b.Path)
-				}
-				fontMap[b.Name] = b
+				continuousFocusPaletteMap[p.ID] = p
 			default:
-				err = traverseGFX(node, path)
+				err := traverseContinuousFocus(node)
 				if err != nil {
 					return err
 				}
@@ -842,69 +497,1209 @@ func traverseGFX(root *ptool.TNode, path string) error {
 	return nil
 }
 
-func parseLoc(path string, i int) error {
-	locFiles, err := WalkMatchExt(filepath.Join(path, "localisation"), ".yml")
+func parseSearchFilters(path string) error {
+	dir := filepath.Join(path, "common", "search_filter_prios")
+	if _, err := os.Stat(dir); err != nil {
+		return nil
+	}
+	files, err := WalkMatchExt(dir, ".txt")
 	if err != nil {
 		return err
 	}
-
-	var locReplaceFiles []string
-	if _, err := os.Stat(filepath.Join(path, "localisation", "replace")); os.IsExist(err) {
-		locReplaceFiles, err = WalkMatchExt(filepath.Join(path, "localisation", "replace"), ".yml")
+	for _, fPath := range files {
+		fmt.Println(fPath)
+		f, err := readFile(fPath)
 		if err != nil {
 			return err
 		}
-	}
 
-	locFiles = append(locFiles, locReplaceFiles...)
+		if len(f) > 0 {
+			// Remove utf-8 bom if found.
+			if bytes.HasPrefix([]byte(f), utf8bom) {
+				f = string(bytes.TrimPrefix([]byte(f), utf8bom))
+			}
+
+			node, err := parsePDX(pdx, f, fPath)
+			if err != nil {
+				return err
+			}
+			traverseSearchFilters(node)
+		}
+	}
+	return nil
+}
+
+// traverseSearchFilters reads filter names from "search_filter_prios = { FILTER = priority }" blocks.
+func traverseSearchFilters(root *ptool.TNode) {
+	for _, node := range root.Links {
+		if pdx.ByID(node.Type) != "declrScope" || strings.ToLower(node.Links[0].Value) != "search_filter_prios" {
+			continue
+		}
+		for _, link := range node.Links[1:] {
+			if pdx.ByID(link.Type) == "declr" {
+				searchFilterDefs[link.Links[0].Value] = true
+			}
+		}
+	}
+}
+
+func parseInlayWindows(path string) error {
+	dir := filepath.Join(path, "common", "focus_inlay_windows")
+	if _, err := os.Stat(dir); err != nil {
+		return nil
+	}
+	files, err := WalkMatchExt(dir, ".txt")
+	if err != nil {
+		return err
+	}
+	for _, fPath := range files {
+		fmt.Println(fPath)
+		f, err := readFile(fPath)
+		if err != nil {
+			return err
+		}
+
+		if len(f) > 0 {
+			// Remove utf-8 bom if found.
+			if bytes.HasPrefix([]byte(f), utf8bom) {
+				f = string(bytes.TrimPrefix([]byte(f), utf8bom))
+			}
+
+			node, err := parsePDX(pdx, f, fPath)
+			if err != nil {
+				return err
+			}
+			_ = node
+			// fmt.Println(ptool.TreeToString(node, pdx.ByID))
+			traverseInlayWindows(node)
+		}
+	}
+	return nil
+}
+
+// traverseInlayWindows reads inlay window definitions in their default state.
+// Scripted image slot shows the first image with a true trigger, or the last one if none are true.
+// Triggers that can't be evaluated are treated as true for visibility and as false for images.
+func traverseInlayWindows(root *ptool.TNode) {
+	for _, node := range root.Links {
+		nodeType := pdx.ByID(node.Type)
+		if nodeType != "declrScope" {
+			continue
+		}
+		w := InlayWindow{ID: node.Links[0].Value, Visible: true, ScriptedImages: make(map[string]string)}
+		for _, link := range node.Links {
+			nodeType := pdx.ByID(link.Type)
+			switch nodeType {
+			case "declr":
+				switch strings.ToLower(link.Links[0].Value) {
+				case "window_name":
+					w.WindowName = link.Links[1].Value
+				}
+			case "declrScope":
+				switch strings.ToLower(link.Links[0].Value) {
+				case "visible":
+					if visible, ok := evalTrigger(link, "and", ""); ok {
+						w.Visible = visible
+					}
+				case "scripted_images":
+					for _, slot := range link.Links {
+						if pdx.ByID(slot.Type) != "declrScope" {
+							continue
+						}
+						var chosen, last string
+						for _, link := range slot.Links {
+							var r, ok bool
+							nodeType := pdx.ByID(link.Type)
+							switch nodeType {
+							case "declr":
+								r, ok = strings.ToLower(link.Links[1].Value) == "yes", true
+							case "declrScope":
+								r, ok = evalTrigger(link, "and", "")
+							default:
+								continue
+							}
+							last = link.Links[0].Value
+							if chosen == "" && ok && r {
+								chosen = last
+							}
+						}
+						if chosen == "" {
+							chosen = last
+						}
+						w.ScriptedImages[slot.Links[0].Value] = chosen
+						gfxList = append(gfxList, "\""+chosen+"\"")
+					}
+				case "scripted_buttons":
+					for _, button := range link.Links {
+						if pdx.ByID(button.Type) != "declrScope" {
+							continue
+						}
+						for _, link := range button.Links {
+							if pdx.ByID(link.Type) == "declrScope" && strings.ToLower(link.Links[0].Value) == "visible" {
+								if visible, ok := evalTrigger(link, "and", ""); ok && !visible {
+									w.HiddenButtons = append(w.HiddenButtons, button.Links[0].Value)
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+		inlayWindowMap[w.ID] = w
+	}
+}
+
+// evalTrigger evaluates trigger block against the assumed game state.
+// Triggers the tool knows nothing about are skipped, ok is false if none of them were known.
+// OR and NOT blocks with any unknown trigger are unknown, as their result can't be trusted then.
+// tag and original_tag triggers are compared with the tag, they are unknown if it is empty.
+func evalTrigger(root *ptool.TNode, op, tag string) (result, ok bool) {
+	result = op == "and"
+	for _, link := range root.Links {
+		var r, known bool
+		nodeType := pdx.ByID(link.Type)
+		switch nodeType {
+		case "declr":
+			switch strings.ToLower(link.Links[0].Value) {
+			case "always":
+				r, known = strings.ToLower(link.Links[1].Value) == "yes", true
+			case "has_dlc":
+				r, known = isDLCOwned(trimQuotes(link.Links[1].Value)), true
+			case "tag", "original_tag":
+				if tag != "" {
+					r, known = strings.EqualFold(trimQuotes(link.Links[1].Value), tag), true
+				}
+			case "has_country_flag":
+				if strings.ToLower(link.Links[1].Value) == "romanov_enabled" { // Poland tree workaround
+					r, known = false, true
+				}
+			}
+		case "declrScope":
+			switch strings.ToLower(link.Links[0].Value) {
+			case "and":
+				r, known = evalTrigger(link, "and", tag)
+			case "or":
+				r, known = evalTrigger(link, "or", tag)
+			case "not":
+				// NOT is true only if none of the triggers inside are true.
+				r, known = evalTrigger(link, "or", tag)
+				r = !r
+			}
+		}
+		if !known {
+			// Block name is not a trigger.
+			if op == "or" && nodeType != "var" {
+				return false, false
+			}
+			continue
+		}
+		ok = true
+		if op == "and" {
+			result = result && r
+		} else {
+			result = result || r
+		}
+	}
+	return result, ok
+}
+
+// parseScriptedLoc reads defined_text entries from common/scripted_localisation of every mod path in load order.
+// The last definition of the name wins.
+func parseScriptedLoc() error {
+	scriptedLocMap = make(map[string]ScriptedLoc)
+	for _, path := range modPaths {
+		dir := filepath.Join(path, "common", "scripted_localisation")
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		files, err := WalkMatchExt(dir, ".txt")
+		if err != nil {
+			return err
+		}
+		for _, fPath := range files {
+			fmt.Println(fPath)
+			f, err := readFile(fPath)
+			if err != nil {
+				return err
+			}
 
-	for _, lPath := range locFiles {
-		f, err := readFile(lPath)
-		if err != nil {
-			return err
-		}
-		if stringContainsSlice(f, locList) {
 			if len(f) > 0 {
 				// Remove utf-8 bom if found.
 				if bytes.HasPrefix([]byte(f), utf8bom) {
 					f = string(bytes.TrimPrefix([]byte(f), utf8bom))
 				}
 
-				// Skip file if it contains a wrong language.
-				if !strings.HasPrefix(strings.TrimSpace(f), language) {
-					continue
+				node, err := parsePDX(pdx, f, fPath)
+				if err != nil {
+					return err
 				}
+				traverseScriptedLoc(node)
+			}
+		}
+	}
+	return nil
+}
 
-				fmt.Println(lPath)
+func traverseScriptedLoc(root *ptool.TNode) {
+	for _, node := range root.Links {
+		nodeType := pdx.ByID(node.Type)
+		switch nodeType {
+		case "declrScope":
+			switch strings.ToLower(node.Links[0].Value) {
+			case "defined_text":
+				var s ScriptedLoc
+				for _, link := range node.Links {
+					nodeType := pdx.ByID(link.Type)
+					switch nodeType {
+					case "declr":
+						switch strings.ToLower(link.Links[0].Value) {
+						case "name":
+							s.Name = trimQuotes(link.Links[1].Value)
+						}
+					case "declrScope":
+						switch strings.ToLower(link.Links[0].Value) {
+						case "text":
+							var t ScriptedLocText
+							for _, link := range link.Links {
+								nodeType := pdx.ByID(link.Type)
+								switch nodeType {
+								case "declr":
+									switch strings.ToLower(link.Links[0].Value) {
+									case "localization_key", "localisation_key":
+										t.Key = trimQuotes(link.Links[1].Value)
+									}
+								case "declrScope":
+									switch strings.ToLower(link.Links[0].Value) {
+									case "trigger":
+										t.HasTrigger = true
+										// Texts are picked for the country the focus tree is made for.
+										t.Result, t.Known = evalTrigger(link, "and", focusTree.Tag)
+									}
+								}
+							}
+							if t.Key != "" {
+								s.Texts = append(s.Texts, t)
+							}
+						}
+					}
+				}
+				if s.Name != "" {
+					scriptedLocMap[s.Name] = s
+				}
+			default:
+				traverseScriptedLoc(node)
+			}
+		}
+	}
+}
 
-				node, err := yml.Parse(f)
+// focusGUIElementNames holds top level GUI elements used to draw focus tree.
+var focusGUIElementNames = []string{
+	"nationalfocusview",
+	"national_focus_item",
+	"national_focus_link",
+	"national_focus_exclusive_item",
+	"focus_spacing",
+	"link_spacing",
+	"link_offsets",
+	"link_begin",
+	"link_end",
+	"exclusive_offset",
+	"exclusive_offset_left",
+	"exclusive_positioning",
+}
+
+// parseGUI reads top level GUI elements with given names from every .gui file in load order.
+// The last definition of the element wins, like it does in game. See modFiles for the order of the files.
+func parseGUI(names []string) error {
+	guiElementMap = make(map[string]*GUIElement)
+	guiBaseElementMap = make(map[string]*GUIElement)
+
+	guiFiles, err := modFiles("interface", ".gui")
+	if err != nil {
+		return err
+	}
+	for _, fPath := range guiFiles {
+		f, err := readFile(fPath)
+		if err != nil {
+			return err
+		}
+
+		if stringContainsSlice(f, names) {
+			fmt.Println(fPath)
+			if len(f) > 0 {
+				// Remove utf-8 bom if found.
+				if bytes.HasPrefix([]byte(f), utf8bom) {
+					f = string(bytes.TrimPrefix([]byte(f), utf8bom))
+				}
+
+				node, err := parsePDX(pdx, f, fPath)
 				if err != nil {
 					return err
 				}
 				_ = node
-				// fmt.Println(ptool.TreeToString(node, yml.ByID))
+				// fmt.Println(ptool.TreeToString(node, pdx.ByID))
+				for _, e := range traverseGUI(node) {
+					if !containsString(names, e.Name) {
+						continue
+					}
+					e.File = fPath
+					guiElementMap[strings.ToLower(e.Name)] = e
+					if isInDir(gamePath, fPath) {
+						guiBaseElementMap[strings.ToLower(e.Name)] = e
+					}
+				}
+			}
+		}
+	}
+
+	// Report which file each of the elements came from.
+	for _, name := range names {
+		if e, ok := guiElementMap[strings.ToLower(name)]; ok {
+			ansi.Println("\x1b[30;1m" + e.Name + ": " + e.File + "\x1b[0m")
+		}
+	}
+
+	for _, name := range names {
+		if !containsString(focusGUIElementNames, name) {
+			if e, ok := guiElementMap[strings.ToLower(name)]; ok {
+				collectGUIAssets(e)
+			}
+		}
+	}
+	return buildFocusGUI()
+}
+
+// traverseGUI returns top level GUI elements.
+func traverseGUI(root *ptool.TNode) []*GUIElement {
+	var elements []*GUIElement
+	for _, node := range root.Links {
+		nodeType := pdx.ByID(node.Type)
+		switch nodeType {
+		case "declrScope":
+			if isGUIElementType(node.Links[0].Value) {
+				elements = append(elements, parseGUIElement(node))
+			} else {
+				elements = append(elements, traverseGUI(node)...)
+			}
+		}
+	}
+	return elements
+}
+
+// isGUIElementType reports if block is a GUI element, like containerWindowType or iconType.
+func isGUIElementType(key string) bool {
+	return strings.HasSuffix(strings.ToLower(key), "type")
+}
+
+func parseGUIElement(root *ptool.TNode) *GUIElement {
+	e := &GUIElement{
+		Type:       strings.ToLower(root.Links[0].Value),
+		Properties: make(map[string]string),
+	}
+	readGUIProperties(e, root, "")
+	e.Name = e.Properties["name"]
+	return e
+}
+
+// readGUIProperties stores element properties with lowercased keys.
+// Keys of the nested blocks are joined with dots, like "position.x".
+func readGUIProperties(e *GUIElement, root *ptool.TNode, prefix string) {
+	for _, link := range root.Links {
+		nodeType := pdx.ByID(link.Type)
+		switch nodeType {
+		case "declr":
+			e.Properties[prefix+strings.ToLower(link.Links[0].Value)] = trimQuotes(link.Links[1].Value)
+		case "declrScope":
+			key := strings.ToLower(link.Links[0].Value)
+			if prefix == "" && isGUIElementType(key) {
+				e.Children = append(e.Children, parseGUIElement(link))
+			} else {
+				readGUIProperties(e, link, prefix+key+".")
+			}
+		case "list":
+			var values []string
+			for _, v := range nodesToString(link) {
+				values = append(values, trimQuotes(v))
+			}
+			e.Properties[strings.TrimSuffix(prefix, ".")] = strings.Join(values, " ")
+		}
+	}
+}
+
+// collectGUIAssets adds sprites, fonts and texts used by the element and its children to the lists of needed definitions.
+func collectGUIAssets(e *GUIElement) {
+	for key, value := range e.Properties {
+		switch {
+		case value == "":
+		case key == "spritetype", key == "quadtexturesprite", strings.HasSuffix(key, ".spritetype"), strings.HasSuffix(key, ".quadtexturesprite"), key == "font":
+			gfxList = append(gfxList, "\""+value+"\"")
+		case key == "text":
+			locList = append(locList, value)
+		}
+	}
+	for _, child := range e.Children {
+		collectGUIAssets(child)
+	}
+}
+
+// buildFocusGUI fills FocusGUI with the elements used to draw focus tree.
+func buildFocusGUI() error {
+	var err error
+	gui = FocusGUI{}
+
+	if e := findGUIElement("nationalfocusview/national_focus_title"); e != nil {
+		collectGUIAssets(e)
+		gui.NationalFocusTitle, err = e.InstantTextboxType()
+		if err != nil {
+			return err
+		}
+	}
+
+	if e := findGUIElement("national_focus_item"); e != nil {
+		collectGUIAssets(e)
+		gui.Item = e
+		gui.NationalFocusItem, err = e.ContainerWindowType()
+		if err != nil {
+			return err
+		}
+		if c := e.Find("bg"); c != nil {
+			gui.BG, err = c.ButtonType()
+			if err != nil {
+				return err
+			}
+		}
+		if c := e.Find("symbol"); c != nil {
+			gui.Symbol, err = c.ButtonType()
+			if err != nil {
+				return err
+			}
+		}
+		if c := e.Find("name"); c != nil {
+			gui.Name, err = c.InstantTextboxType()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if e := findGUIElement("national_focus_link"); e != nil {
+		collectGUIAssets(e)
+		gui.NationalFocusLink, err = e.ContainerWindowType()
+		if err != nil {
+			return err
+		}
+		if c := e.Find("link"); c != nil {
+			gui.Link, err = c.IconType()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if e := findGUIElement("national_focus_exclusive_item"); e != nil {
+		collectGUIAssets(e)
+		gui.NationalFocusExclusiveItem, err = e.ContainerWindowType()
+		if err != nil {
+			return err
+		}
+		icons := map[string]*IconType{
+			"link1": &gui.Link1,
+			"link2": &gui.Link2,
+			"left":  &gui.Left,
+			"right": &gui.Right,
+			"mid":   &gui.Mid,
+		}
+		for name, icon := range icons {
+			if c := e.Find(name); c != nil {
+				*icon, err = c.IconType()
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	positions := map[string]*image.Point{
+		"focus_spacing":         &gui.FocusSpacing,
+		"link_spacing":          &gui.LinkSpacing,
+		"link_offsets":          &gui.LinkOffsets,
+		"link_begin":            &gui.LinkBegin,
+		"link_end":              &gui.LinkEnd,
+		"exclusive_offset":      &gui.ExclusiveOffset,
+		"exclusive_offset_left": &gui.ExclusiveOffsetLeft,
+		"exclusive_positioning": &gui.ExclusivePositioning,
+	}
+	for name, pos := range positions {
+		if e := findGUIElement(name); e != nil && e.Type == "positiontype" {
+			*pos, err = e.Point("position")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// findGUIElement returns element by slash separated path of names, starting with the top level element.
+func findGUIElement(path string) *GUIElement {
+	names := strings.SplitN(path, "/", 2)
+	e, ok := guiElementMap[strings.ToLower(names[0])]
+	if !ok {
+		return nil
+	}
+	if len(names) > 1 {
+		return e.Find(names[1])
+	}
+	return e
+}
+
+// isBaseGUIElement reports if element with the path is defined by the game itself.
+func isBaseGUIElement(path string) bool {
+	names := strings.SplitN(path, "/", 2)
+	e, ok := guiBaseElementMap[strings.ToLower(names[0])]
+	if !ok {
+		return false
+	}
+	return len(names) == 1 || e.Find(names[1]) != nil
+}
+
+// Find returns child element by slash separated path of names.
+func (e *GUIElement) Find(path string) *GUIElement {
+	names := strings.SplitN(path, "/", 2)
+	for _, child := range e.Children {
+		if strings.EqualFold(child.Name, names[0]) {
+			if len(names) > 1 {
+				return child.Find(names[1])
+			}
+			return child
+		}
+	}
+	return nil
+}
+
+// String returns property value by its lowercased key.
+func (e *GUIElement) String(key string) string {
+	return e.Properties[key]
+}
+
+// Int returns property value as an integer, missing property is 0.
+func (e *GUIElement) Int(key string) (int, error) {
+	v, ok := e.Properties[key]
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%v %v: %v", e.Name, key, err)
+	}
+	return int(math.Trunc(n)), nil
+}
+
+// Size returns element size, declared either with x and y or with width and height.
+func (e *GUIElement) Size() (image.Point, error) {
+	size, err := e.Point("size")
+	if err != nil || size != (image.Point{}) {
+		return size, err
+	}
+	size.X, err = e.Int("size.width")
+	if err != nil {
+		return size, err
+	}
+	size.Y, err = e.Int("size.height")
+	return size, err
+}
+
+// Point returns x and y values of the block property, like position.
+func (e *GUIElement) Point(key string) (image.Point, error) {
+	var p image.Point
+	var err error
+	p.X, err = e.Int(key + ".x")
+	if err != nil {
+		return p, err
+	}
+	p.Y, err = e.Int(key + ".y")
+	return p, err
+}
+
+func (e *GUIElement) ContainerWindowType() (ContainerWindowType, error) {
+	var w ContainerWindowType
+	var err error
+	w.Name = e.Name
+	w.Position, err = e.Point("position")
+	if err != nil {
+		return w, err
+	}
+	w.Width, err = e.Int("size.width")
+	if err != nil {
+		return w, err
+	}
+	w.Height, err = e.Int("size.height")
+	return w, err
+}
+
+func (e *GUIElement) ButtonType() (ButtonType, error) {
+	var b ButtonType
+	var err error
+	b.Name = e.Name
+	b.Position, err = e.Point("position")
+	b.SpriteType = e.String("spritetype")
+	if b.SpriteType == "" {
+		b.SpriteType = e.String("quadtexturesprite")
+	}
+	b.CenterPosition = e.String("centerposition")
+	b.Orientation = e.String("orientation")
+	if err != nil {
+		return b, err
+	}
+	b.Frame, err = e.Int("frame")
+	return b, err
+}
+
+func (e *GUIElement) IconType() (IconType, error) {
+	var i IconType
+	var err error
+	i.Name = e.Name
+	i.Position, err = e.Point("position")
+	if err != nil {
+		return i, err
+	}
+	i.SpriteType = e.String("spritetype")
+	i.Frame, err = e.Int("frame")
+	return i, err
+}
+
+func (e *GUIElement) InstantTextboxType() (InstantTextboxType, error) {
+	var t InstantTextboxType
+	var err error
+	t.Name = e.Name
+	t.Position, err = e.Point("position")
+	if err != nil {
+		return t, err
+	}
+	t.Orientation = e.String("orientation")
+	t.Text = e.String("text")
+	t.Font = e.String("font")
+	t.MaxWidth, err = e.Int("maxwidth")
+	if err != nil {
+		return t, err
+	}
+	t.MaxHeight, err = e.Int("maxheight")
+	if err != nil {
+		return t, err
+	}
+	t.Format = e.String("format")
+	t.VerticalAlignment = e.String("vertical_alignment")
+	return t, nil
+}
+
+// parseGFX parses gfx files that define needed sprites and fonts.
+// Files are parsed concurrently and merged in load order, so definitions of the later mod win.
+func parseGFX() error {
+	// Name indexes are stored in parse cache.
+	loadParseCache()
+
+	files, roots, err := gfxFiles()
+	if err != nil {
+		return err
+	}
+
+	results, err := parseConcurrently(files, pdxParsers, func(parser *ptool.TParser, i int) (ParsedFile, error) {
+		return cachedParse(files[i], func(f, fPath string) (ParsedFile, error) { return parseGFXFile(parser, f, fPath, roots[i]) })
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, p := range results {
+		fmt.Println(files[i])
+		for _, s := range p.Sprites {
+			gfxMap[s.Name] = s
+		}
+		for _, b := range p.Fonts {
+			// Font paths might be replaced later, cached ones must stay intact.
+			b.Fontfiles = append([]string{}, b.Fontfiles...)
+			if len(b.Languages) == 0 {
+				fontMap[b.Name] = b
+			}
+			for _, lang := range b.Languages {
+				fontMap[languageFontName(b.Name, lang)] = b
+			}
+		}
+		for code, c := range p.TextColors {
+			textColors[code] = c
+		}
+		pBar.SetValue(pBar.Value + 0.4/float64(len(files)))
+	}
+	return nil
+}
+
+// gfxFiles returns gfx files that define needed sprites and fonts in load order and mod paths they belong to.
+// Files replaced by the later mods with the same relative path are dropped.
+func gfxFiles() (files, roots []string, err error) {
+	var dirs []string
+	var matched [][]string
+	for _, path := range modPaths {
+		dir := filepath.Join(path, "interface")
+		gfxFiles, err := indexedFiles(gfxIndex, dir, ".gfx", gfxNames, append(gfxList, textColorsName))
+		if err != nil {
+			return nil, nil, err
+		}
+		dirs = append(dirs, dir)
+		matched = append(matched, gfxFiles)
+	}
+
+	// Index holds every file of the folder, so files that define none of the names still replace earlier ones.
+	owners := make(map[string]string)
+	for _, dir := range dirs {
+		for fPath := range gfxIndex[dir].Files {
+			owners[relPath(dir, fPath)] = fPath
+		}
+	}
+	for i, dir := range dirs {
+		for _, fPath := range matched[i] {
+			if owners[relPath(dir, fPath)] != fPath {
+				continue
+			}
+			files = append(files, fPath)
+			roots = append(roots, modPaths[i])
+		}
+	}
+	return files, roots, nil
+}
+
+// parseGFXFile returns sprites and fonts defined in gfx file contents.
+func parseGFXFile(parser *ptool.TParser, f, fPath, path string) (ParsedFile, error) {
+	p := ParsedFile{Parsed: true}
+	if len(f) > 0 {
+		// Remove utf-8 bom if found.
+		if bytes.HasPrefix([]byte(f), utf8bom) {
+			f = string(bytes.TrimPrefix([]byte(f), utf8bom))
+		}
+
+		node, err := parsePDX(parser, f, fPath)
+		if err != nil {
+			return p, err
+		}
+		// fmt.Println(ptool.TreeToString(node, pdx.ByID))
+		err = traverseGFX(node, path, &p)
+		if err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
 
-				err = traverseLoc(node)
+func traverseGFX(root *ptool.TNode, path string, p *ParsedFile) error {
+	var err error
+	for _, node := range root.Links {
+		nodeType := pdx.ByID(node.Type)
+		switch nodeType {
+		case "declrScope":
+			switch strings.ToLower(node.Links[0].Value) {
+			case "spritetype", "corneredtilespritetype", "frameanimatedspritetype", "textspritetype", "maskedshieldtype", "progressbartype":
+				s, err := parseSpriteType(node, path)
+				if err != nil {
+					return err
+				}
+				p.Sprites = append(p.Sprites, s)
+			case "bitmapfont", "bitmapfont_override":
+				var b BitmapFont
+				for _, link := range node.Links {
+					nodeType := pdx.ByID(link.Type)
+					switch nodeType {
+					case "declr":
+						switch strings.ToLower(link.Links[0].Value) {
+						case "name":
+							b.Name = link.Links[1].Value
+						case "path":
+							b.Path = filepath.Join(path, link.Links[1].Value)
+						}
+					case "declrScope":
+						switch strings.ToLower(link.Links[0].Value) {
+						case "fontfiles":
+							for _, link := range link.Links {
+								nodeType := pdx.ByID(link.Type)
+								switch nodeType {
+								case "list":
+									for _, link := range link.Links {
+										nodeType := pdx.ByID(link.Type)
+										switch nodeType {
+										case "anyType":
+											b.Fontfiles = append(b.Fontfiles, filepath.Join(path, trimQuotes(link.Value)))
+										}
+
+									}
+								}
+							}
+						case "languages":
+							for _, link := range link.Links {
+								nodeType := pdx.ByID(link.Type)
+								switch nodeType {
+								case "list":
+									for _, link := range link.Links {
+										nodeType := pdx.ByID(link.Type)
+										switch nodeType {
+										case "anyType":
+											b.Languages = append(b.Languages, strings.ToLower(trimQuotes(link.Value)))
+										}
+									}
+								}
+							}
+						}
+					}
+				}
+				if len(b.Fontfiles) < 1 && b.Path != "" {
+					b.Fontfiles = append(b.Fontfiles, b.Path)
+				}
+				p.Fonts = append(p.Fonts, b)
+			case "textcolors":
+				if p.TextColors == nil {
+					p.TextColors = make(map[string]color.NRGBA)
+				}
+				for _, link := range node.Links {
+					if pdx.ByID(link.Type) == "declrScope" {
+						c, err := parseColor(link)
+						if err != nil {
+							return err
+						}
+						p.TextColors[link.Links[0].Value] = c
+					}
+				}
+			default:
+				err = traverseGFX(node, path, p)
 				if err != nil {
 					return err
 				}
 			}
 		}
-		pBar.SetValue(pBar.Value + 0.4/float64(i)/float64(len(locFiles)))
 	}
 	return nil
 }
 
-func traverseLoc(root *ptool.TNode) error {
+func parseSpriteType(root *ptool.TNode, path string) (SpriteType, error) {
+	s := SpriteType{Type: strings.ToLower(root.Links[0].Value)}
+	var err error
+	for _, link := range root.Links {
+		nodeType := pdx.ByID(link.Type)
+		switch nodeType {
+		case "declr":
+			value := link.Links[1].Value
+			switch strings.ToLower(link.Links[0].Value) {
+			case "name":
+				s.Name = value
+			case "texturefile":
+				s.TextureFile = filepath.Join(path, value)
+			case "texturefile1":
+				s.TextureFile1 = filepath.Join(path, value)
+			case "texturefile2":
+				s.TextureFile2 = filepath.Join(path, value)
+			case "effectfile":
+				s.EffectFile = filepath.Join(path, value)
+			case "noofframes":
+				s.NoOfFrames, err = strconv.Atoi(value)
+			case "loadtype":
+				s.LoadType = value
+			case "alwaystransparent":
+				s.AlwaysTransparent = strings.ToLower(value) == "yes"
+			case "horizontal":
+				s.Horizontal = strings.ToLower(value) == "yes"
+			case "tilingcenter":
+				s.TilingCenter = strings.ToLower(value) == "yes"
+			case "animation_rate_fps":
+				s.AnimationRateFPS, err = strconv.ParseFloat(value, 64)
+			case "looping":
+				s.Looping = strings.ToLower(value) == "yes"
+			case "play_on_show":
+				s.PlayOnShow = strings.ToLower(value) == "yes"
+			case "pause_on_loop":
+				s.PauseOnLoop, err = strconv.ParseFloat(value, 64)
+			}
+		case "declrScope":
+			switch strings.ToLower(link.Links[0].Value) {
+			case "bordersize":
+				s.BorderSize, err = parsePosition(link)
+			case "size":
+				s.Size, err = parsePosition(link)
+			case "color":
+				s.Color, err = parseColor(link)
+			case "color2":
+				s.Color2, err = parseColor(link)
+			case "animation":
+				var a SpriteAnimation
+				a, err = parseSpriteAnimation(link, path)
+				s.Animations = append(s.Animations, a)
+			}
+		}
+		if err != nil {
+			return s, fmt.Errorf("%v: %v", s.Name, err)
+		}
+	}
+	if s.TextureFile == "" {
+		s.TextureFile = s.TextureFile1
+	}
+	return s, nil
+}
+
+func parseSpriteAnimation(root *ptool.TNode, path string) (SpriteAnimation, error) {
+	var a SpriteAnimation
+	var err error
+	for _, link := range root.Links {
+		nodeType := pdx.ByID(link.Type)
+		switch nodeType {
+		case "declr":
+			value := link.Links[1].Value
+			switch strings.ToLower(link.Links[0].Value) {
+			case "animationmaskfile":
+				a.MaskFile = filepath.Join(path, value)
+			case "animationtexturefile":
+				a.TextureFile = filepath.Join(path, value)
+			case "animationrotation":
+				a.Rotation, err = strconv.ParseFloat(value, 64)
+			case "animationlooping":
+				a.Looping = strings.ToLower(value) == "yes"
+			case "animationtime":
+				a.Time, err = strconv.ParseFloat(value, 64)
+			case "animationdelay":
+				a.Delay, err = strconv.ParseFloat(value, 64)
+			case "animationblendmode":
+				a.BlendMode = value
+			case "animationtype":
+				a.Type = value
+			}
+		case "declrScope":
+			switch strings.ToLower(link.Links[0].Value) {
+			case "animationrotationoffset":
+				a.RotationOffset, err = parseFloatPoint(link)
+			case "animationtexturescale":
+				a.TextureScale, err = parseFloatPoint(link)
+			case "animationframes":
+				for _, v := range listValues(link) {
+					var n int
+					n, err = strconv.Atoi(v)
+					if err != nil {
+						break
+					}
+					a.Frames = append(a.Frames, n)
+				}
+			}
+		}
+		if err != nil {
+			return a, err
+		}
+	}
+	return a, nil
+}
+
+func parseFloatPoint(root *ptool.TNode) (FloatPoint, error) {
+	var p FloatPoint
+	var err error
+	for _, link := range root.Links {
+		nodeType := pdx.ByID(link.Type)
+		switch nodeType {
+		case "declr":
+			switch strings.ToLower(link.Links[0].Value) {
+			case "x":
+				p.X, err = strconv.ParseFloat(link.Links[1].Value, 64)
+			case "y":
+				p.Y, err = strconv.ParseFloat(link.Links[1].Value, 64)
+			}
+		}
+		if err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// parseColor reads color block with values either in 0-1 or 0-255 range.
+func parseColor(root *ptool.TNode) (color.NRGBA, error) {
+	c := color.NRGBA{A: 255}
+	values := listValues(root)
+	scale := 1.0
+	var channels []float64
+	for _, v := range values {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return c, err
+		}
+		if n > 1 {
+			scale = 255
+		}
+		channels = append(channels, n)
+	}
+	dst := []*uint8{&c.R, &c.G, &c.B, &c.A}
+	for i, n := range channels {
+		if i < len(dst) {
+			*dst[i] = uint8(math.Round(n / scale * 255))
+		}
+	}
+	return c, nil
+}
+
+// listValues returns values of the list inside the block.
+func listValues(root *ptool.TNode) []string {
+	var values []string
+	for _, link := range root.Links {
+		nodeType := pdx.ByID(link.Type)
+		switch nodeType {
+		case "list":
+			for _, link := range link.Links {
+				nodeType := pdx.ByID(link.Type)
+				switch nodeType {
+				case "anyType":
+					values = append(values, trimQuotes(link.Value))
+				}
+			}
+		}
+	}
+	return values
+}
+
+// parseLoc parses localisation files that define needed keys and merges them key by key in load order.
+// Files are parsed concurrently, per language subfolders are read with the rest of the folder.
+// Files in replace folders are merged after all other ones.
+// A file of the later mod with the same path inside localisation folder replaces the file of the earlier one.
+func parseLoc() error {
+	loadParseCache()
+
+	files, err := locFiles()
+	if err != nil {
+		return err
+	}
+
+	results, err := parseConcurrently(files, ymlParsers, func(parser *ptool.TParser, i int) (ParsedFile, error) {
+		return cachedParse(files[i], func(f, lPath string) (ParsedFile, error) { return parseLocFile(parser, f, lPath) })
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, p := range results {
+		pBar.SetValue(pBar.Value + 0.4/float64(len(files)))
+		// Skip file if it contains a wrong language.
+		if p.Language != language {
+			continue
+		}
+
+		fmt.Println(files[i])
+		mergeLocFile(p)
+	}
+	return nil
+}
+
+// locFiles returns localisation files that define needed keys in the order they are merged.
+// Files replaced by the later mods with the same relative path are dropped,
+// files of replace folders come after all other files.
+func locFiles() ([]string, error) {
+	var dirs []string
+	var matched [][]string
+	for _, path := range modPaths {
+		dir := filepath.Join(path, "localisation")
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		// Only files defining needed keys are parsed.
+		locFiles, err := indexedFiles(locIndex, dir, ".yml", locKeys, locList)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, dir)
+		matched = append(matched, locFiles)
+	}
+
+	// Index holds every file of the folder, so files that define none of the keys still replace earlier ones.
+	owners := make(map[string]string)
+	for _, dir := range dirs {
+		for fPath := range locIndex[dir].Files {
+			owners[relPath(dir, fPath)] = fPath
+		}
+	}
+
+	var files, replaceFiles []string
+	for i, dir := range dirs {
+		for _, fPath := range matched[i] {
+			rel := relPath(dir, fPath)
+			if owners[rel] != fPath {
+				continue
+			}
+			if isLocReplacePath(rel) {
+				replaceFiles = append(replaceFiles, fPath)
+			} else {
+				files = append(files, fPath)
+			}
+		}
+	}
+	return append(files, replaceFiles...), nil
+}
+
+// mergeLocFile adds localisation entries of the parsed file to locMap, replacing the earlier ones.
+func mergeLocFile(p ParsedFile) {
+	for lang, entries := range p.Loc {
+		if _, ok := locMap[lang]; !ok {
+			locMap[lang] = make(map[string]Localisation)
+		}
+		for _, l := range entries {
+			locMap[lang][l.Key] = l
+		}
+	}
+}
+
+// parseLocFile returns localisation entries of the file contents.
+// Files of other languages are not parsed.
+func parseLocFile(parser *ptool.TParser, f, lPath string) (ParsedFile, error) {
+	// Remove utf-8 bom if found.
+	if bytes.HasPrefix([]byte(f), utf8bom) {
+		f = string(bytes.TrimPrefix([]byte(f), utf8bom))
+	}
+
+	p := ParsedFile{Language: locFileLanguage(f)}
+	if len(f) == 0 || p.Language != language {
+		return p, nil
+	}
+
+	node, err := parseYML(parser, f, lPath)
+	if err != nil {
+		return p, err
+	}
+	// fmt.Println(ptool.TreeToString(node, yml.ByID))
+
+	p.Parsed = true
+	p.Loc = make(map[string][]Localisation)
+	err = traverseLoc(node, &p)
+	if err != nil {
+		return p, err
+	}
+
+	// Line numbers are taken from the file text, in the order keys are defined.
+	lines := locKeyLines(f)
+	for _, entries := range p.Loc {
+		for i, l := range entries {
+			entries[i].File = lPath
+			if n := lines[l.Key]; len(n) > 0 {
+				entries[i].Line, lines[l.Key] = n[0], n[1:]
+			}
+		}
+	}
+	return p, nil
+}
+
+// locFileLanguage returns the language from the header of localisation file.
+func locFileLanguage(f string) string {
+	header := strings.TrimSpace(f)
+	if i := strings.IndexAny(header, ":\r\n"); i >= 0 {
+		header = header[:i]
+	}
+	return header
+}
+
+func traverseLoc(root *ptool.TNode, p *ParsedFile) error {
 	lang := "l_english"
 	for _, node := range root.Links {
 		nodeType := yml.ByID(node.Type)
 		switch nodeType {
 		case "language":
 			lang = node.Value
-			if _, ok := locMap[lang]; !ok {
-				locMap[lang] = make(map[string]Localisation)
-			}
 		case "pair":
 			var l Localisation
 			for _, link := range node.Links {
@@ -918,9 +1713,9 @@ func traverseLoc(root *ptool.TNode) error {
 					l.Value = trimQuotes(link.Value)
 				}
 			}
-			locMap[lang][l.Key] = l
+			p.Loc[lang] = append(p.Loc[lang], l)
 		default:
-			err := traverseLoc(node)
+			err := traverseLoc(node, p)
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEvalTrigger(t *testing.T) {
+	err := buildParsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		f      string
+		owned  []string
+		result bool
+		ok     bool
+	}{
+		{"every DLC owned by default", `has_dlc = "Waking the Tiger"`, nil, true, true},
+		{"owned DLC", `has_dlc = "Waking the Tiger"`, []string{"Waking the Tiger"}, true, true},
+		{"missing DLC", `has_dlc = "Waking the Tiger"`, []string{"La Resistance"}, false, true},
+		{"not missing DLC", `NOT = { has_dlc = "Waking the Tiger" }`, []string{}, true, true},
+		{"not owned DLC", `NOT = { has_dlc = "Waking the Tiger" }`, nil, false, true},
+		{"or", `OR = { has_dlc = "Waking the Tiger" has_dlc = "La Resistance" }`, []string{"La Resistance"}, true, true},
+		{"and", `has_dlc = "Waking the Tiger" has_dlc = "La Resistance"`, []string{"La Resistance"}, false, true},
+		{"always", `always = no`, nil, false, true},
+		{"unknown triggers are skipped", `has_war = yes always = yes`, nil, true, true},
+		{"only unknown triggers", `has_war = yes`, nil, true, false},
+		{"or with unknown trigger", `OR = { has_dlc = "Waking the Tiger" has_war = yes }`, []string{}, true, false},
+		{"not with unknown trigger", `NOT = { has_dlc = "Waking the Tiger" has_war = yes }`, []string{}, true, false},
+		{"unknown or is skipped", `always = no OR = { has_war = yes }`, nil, false, true},
+	}
+
+	defer func() { ownedDLCs = nil }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ownedDLCs = nil
+			if tt.owned != nil {
+				ownedDLCs = make(map[string]bool)
+				for _, dlc := range tt.owned {
+					ownedDLCs[dlc] = true
+				}
+			}
+
+			node, err := parsePDX(pdx, tt.f, "test.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, ok := evalTrigger(node, "and", "")
+			if result != tt.result || ok != tt.ok {
+				t.Errorf("got %v, %v, want %v, %v", result, ok, tt.result, tt.ok)
+			}
+		})
+	}
+}
+
+func TestEvalTriggerTag(t *testing.T) {
+	err := buildParsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		f      string
+		tag    string
+		result bool
+		ok     bool
+	}{
+		{"tree country", `tag = GER`, "GER", true, true},
+		{"other country", `original_tag = SOV`, "GER", false, true},
+		{"not other country", `NOT = { tag = SOV }`, "GER", true, true},
+		{"no tag", `tag = GER`, "", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := parsePDX(pdx, tt.f, "test.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, ok := evalTrigger(node, "and", tt.tag)
+			if result != tt.result || ok != tt.ok {
+				t.Errorf("got %v, %v, want %v, %v", result, ok, tt.result, tt.ok)
+			}
+		})
+	}
+}
+
+func TestAllowBranchIgnoresTag(t *testing.T) {
+	err := buildParsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tree country is used only for scripted localisation, allow_branch with a tag trigger stays allowed.
+	focusTree.Tag = "GER"
+	defer func() {
+		focusTree = FocusTree{}
+		focusMap = make(map[string]Focus)
+	}()
+	node, err := parsePDX(pdx, `focus = {
+	id = SOV_focus
+	allow_branch = { tag = SOV }
+}
+focus = {
+	id = no_dlc_focus
+	allow_branch = { tag = SOV has_dlc = "Waking the Tiger" }
+}`, "test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownedDLCs = map[string]bool{}
+	defer func() { ownedDLCs = nil }()
+	err = traverseFocus(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !focusMap["SOV_focus"].AllowBranch {
+		t.Error("SOV_focus branch is hidden")
+	}
+	if focusMap["no_dlc_focus"].AllowBranch {
+		t.Error("no_dlc_focus branch is allowed without the DLC")
+	}
+}
+
+func TestTraverseFocusTitleKeys(t *testing.T) {
+	err := buildParsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := parsePDX(pdx, `focus_tree = {
+	focus = { id = A }
+	focus = {
+		id = B
+		text = B_TITLE
+		desc = B_DESC
+	}
+}
+shared_focus = { id = C text = C_TITLE }
+shared_focus = { text = D_TITLE }`, "test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := traverseFocusTitleKeys(node)
+	if want := []string{"A", "B_TITLE", "C_TITLE", "D_TITLE"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("got title keys %q, want %q", keys, want)
+	}
+}
+
+func TestSearchFilterIcon(t *testing.T) {
+	err := buildParsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := parsePDX(pdx, `search_filter_prios = {
+	FOCUS_FILTER_POLITICAL = 200
+	FOCUS_FILTER_INDUSTRY = 100
+}`, "test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	traverseSearchFilters(node)
+	gfxMap["GFX_FOCUS_FILTER_POLITICAL"] = SpriteType{Name: "GFX_FOCUS_FILTER_POLITICAL"}
+	defer func() {
+		searchFilterDefs = make(map[string]bool)
+		gfxMap = make(map[string]SpriteType)
+	}()
+
+	tests := []struct {
+		filter string
+		err    string
+	}{
+		{"FOCUS_FILTER_POLITICAL", ""},
+		{"FOCUS_FILTER_INDUSTRY", `search filter "FOCUS_FILTER_INDUSTRY" icon "GFX_FOCUS_FILTER_INDUSTRY" not found`},
+		{"FOCUS_FILTER_MOD", `search filter "FOCUS_FILTER_MOD" is not defined in common/search_filter_prios`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			s, err := searchFilterIcon(tt.filter)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Name != searchFilterSpriteName(tt.filter) {
+				t.Errorf("got sprite %v", s.Name)
+			}
+		})
+	}
+}
+
+// writeTestFiles creates files with given contents, names are slash separated paths inside dir.
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(f), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTraverseGUI(t *testing.T) {
+	err := buildParsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := readFile("testdata/focus.gui")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := parsePDX(pdx, f, "testdata/focus.gui")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	elements := traverseGUI(node)
+	if len(elements) != 2 {
+		t.Fatalf("got %v elements, want 2", len(elements))
+	}
+	view, item := elements[0], elements[1]
+	if view.Type != "containerwindowtype" || view.Name != "nationalfocusview" || len(view.Children) != 1 {
+		t.Errorf("got view %+v", view)
+	}
+	if item.Name != "national_focus_item" || len(item.Children) != 3 {
+		t.Fatalf("got item %+v", item)
+	}
+
+	// Keys are lowercased, nested blocks are joined with dots and lists are joined with spaces.
+	tests := []struct {
+		e     *GUIElement
+		key   string
+		value string
+	}{
+		{item, "size.width", "100"},
+		{item, "clipping", "no"},
+		{view.Children[0], "maxwidth", "300"},
+		{view.Children[0], "font", "hoi_36header"},
+		{item.Children[0], "quadtexturesprite", "GFX_focus_unavailable"},
+		{item.Children[1], "position.x", "30"},
+		{item.Children[1], "scale", "0.5"},
+		{item.Children[2], "textcolor", "255 255 255"},
+	}
+	for _, tt := range tests {
+		if got := tt.e.Properties[tt.key]; got != tt.value {
+			t.Errorf("%v %v = %q, want %q", tt.e.Name, tt.key, got, tt.value)
+		}
+	}
+	if item.Children[2].Type != "instanttextboxtype" {
+		t.Errorf("got child type %q", item.Children[2].Type)
+	}
+}
+
+func TestFindGUIElement(t *testing.T) {
+	err := buildParsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	focusGUI, err := ioutil.ReadFile("testdata/focus.gui")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mod folder next to the game one shares its name as a prefix, but it is not the game.
+	// Elements are base ones if the game defines them, even when a mod overrides them.
+	dir := t.TempDir()
+	gamePath = filepath.Join(dir, "Hearts of Iron IV")
+	mod := filepath.Join(dir, "Hearts of Iron IV_mods", "mod")
+	modPaths = []string{gamePath, mod}
+	defer func() {
+		gamePath = ""
+		modPaths = nil
+		guiElementMap = make(map[string]*GUIElement)
+		guiBaseElementMap = make(map[string]*GUIElement)
+		gui = FocusGUI{}
+		gfxList, locList = nil, nil
+	}()
+	writeTestFiles(t, gamePath, map[string]string{"interface/nationalfocusview.gui": string(focusGUI)})
+	writeTestFiles(t, mod, map[string]string{"interface/mod_focus.gui": "guiTypes = {\n" +
+		"\tcontainerWindowType = {\n" +
+		"\t\tname = \"national_focus_item\"\n" +
+		"\t\tsize = { width = 120 height = 60 }\n" +
+		"\t\ticonType = { name = \"symbol\" spriteType = \"GFX_mod_unknown\" }\n" +
+		"\t\ticonType = { name = \"mod_layer\" spriteType = \"GFX_mod_layer\" }\n" +
+		"\t}\n" +
+		"}\n"})
+
+	err = parseGUI(focusGUIElementNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		found  bool
+		isBase bool
+	}{
+		{"nationalfocusview", true, true},
+		{"nationalfocusview/national_focus_title", true, true},
+		{"National_Focus_Item/Symbol", true, true},
+		{"national_focus_item/bg", false, true},
+		{"national_focus_item/mod_layer", true, false},
+		{"national_focus_item/missing", false, false},
+		{"missing", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			e := findGUIElement(tt.path)
+			if (e != nil) != tt.found {
+				t.Errorf("findGUIElement() = %v, found %v", e, tt.found)
+			}
+			if got := isBaseGUIElement(tt.path); got != tt.isBase {
+				t.Errorf("isBaseGUIElement() = %v, want %v", got, tt.isBase)
+			}
+		})
+	}
+	if e := findGUIElement("national_focus_item/symbol"); e != nil && e.Properties["spritetype"] != "GFX_mod_unknown" {
+		t.Errorf("got symbol sprite %q from the game file", e.Properties["spritetype"])
+	}
+}
+
+func TestParseGUILoadOrder(t *testing.T) {
+	err := buildParsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	gamePath = filepath.Join(dir, "game")
+	modA := filepath.Join(dir, "mod_a")
+	modB := filepath.Join(dir, "mod_b")
+	modPaths = []string{gamePath, modA, modB}
+	defer func() {
+		gamePath = ""
+		modPaths = nil
+		guiElementMap = make(map[string]*GUIElement)
+		guiBaseElementMap = make(map[string]*GUIElement)
+		gui = FocusGUI{}
+		gfxList, locList = nil, nil
+	}()
+
+	element := func(name, width string) string {
+		return "guiTypes = { containerWindowType = { name = \"" + name + "\" size = { width = " + width + " height = 10 } } }\n"
+	}
+	writeTestFiles(t, gamePath, map[string]string{
+		"interface/a.gui": element("nationalfocusview", "1") + element("national_focus_link", "2"),
+		"interface/b.gui": element("national_focus_item", "100"),
+	})
+	writeTestFiles(t, modA, map[string]string{
+		"interface/c.gui": element("national_focus_item", "110"),
+	})
+	// a.gui replaces the game file, so nationalfocusview is gone and it is read after mod_a files.
+	writeTestFiles(t, modB, map[string]string{
+		"interface/a.gui": element("national_focus_link", "3") + element("national_focus_item", "120"),
+	})
+
+	files, err := modFiles("interface", ".gui")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(gamePath, "interface", "b.gui"),
+		filepath.Join(modA, "interface", "c.gui"),
+		filepath.Join(modB, "interface", "a.gui"),
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("modFiles() = %q, want %q", files, want)
+	}
+
+	err = parseGUI(focusGUIElementNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := findGUIElement("nationalfocusview"); e != nil {
+		t.Errorf("element of the replaced file is found in %v", e.File)
+	}
+	tests := []struct {
+		name  string
+		width string
+		file  string
+	}{
+		{"national_focus_item", "120", want[2]},
+		{"national_focus_link", "3", want[2]},
+	}
+	for _, tt := range tests {
+		e := findGUIElement(tt.name)
+		if e == nil {
+			t.Errorf("%v not found", tt.name)
+			continue
+		}
+		if e.Properties["size.width"] != tt.width || e.File != tt.file {
+			t.Errorf("%v: got width %v from %v, want %v from %v", tt.name, e.Properties["size.width"], e.File, tt.width, tt.file)
+		}
+	}
+}
+
+func TestGFXFiles(t *testing.T) {
+	dir := t.TempDir()
+	game := filepath.Join(dir, "game")
+	mod := filepath.Join(dir, "mod")
+	modPaths = []string{game, mod}
+	gfxList = []string{`"GFX_a"`, `"GFX_b"`, `"GFX_c"`}
+	defer func() {
+		modPaths = nil
+		gfxList = nil
+		gfxIndex = make(map[string]*nameIndex)
+		isParseCacheChanged = false
+	}()
+
+	sprite := func(name string) string {
+		return "spriteTypes = { spriteType = { name = \"" + name + "\" texturefile = \"gfx/" + name + ".dds\" } }\n"
+	}
+	writeTestFiles(t, game, map[string]string{
+		"interface/a.gfx":     sprite("GFX_a") + sprite("GFX_b"),
+		"interface/sub/x.gfx": sprite("GFX_c"),
+		"interface/other.gfx": sprite("GFX_other"),
+	})
+	// Replacing files are matched by relative path in any case, even if they define none of the needed names.
+	writeTestFiles(t, mod, map[string]string{
+		"interface/A.gfx":     sprite("GFX_b"),
+		"interface/SUB/x.gfx": sprite("GFX_other"),
+		"interface/c.gfx":     sprite("GFX_c"),
+	})
+
+	files, roots, err := gfxFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(mod, "interface", "A.gfx"), filepath.Join(mod, "interface", "c.gfx")}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("got files %q, want %q", files, want)
+	}
+	if !reflect.DeepEqual(roots, []string{mod, mod}) {
+		t.Errorf("got roots %q", roots)
+	}
+}
+
+func TestParseBitmapFontOverride(t *testing.T) {
+	err := buildParsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := parseGFXFile(pdx, `bitmapfonts = {
+	bitmapfont = {
+		name = hoi_18
+		fontfiles = { "gfx/fonts/hoi_18" }
+	}
+	bitmapfont_override = {
+		name = hoi_18
+		fontfiles = { "gfx/fonts/hoi_18_cyr" }
+		languages = { "l_russian" "L_Polish" }
+	}
+}`, "fonts.gfx", "mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BitmapFont{
+		{Name: "hoi_18", Fontfiles: []string{filepath.Join("mod", "gfx/fonts/hoi_18")}},
+		{Name: "hoi_18", Fontfiles: []string{filepath.Join("mod", "gfx/fonts/hoi_18_cyr")}, Languages: []string{"l_russian", "l_polish"}},
+	}
+	if !reflect.DeepEqual(p.Fonts, want) {
+		t.Errorf("got fonts %+v, want %+v", p.Fonts, want)
+	}
+}
@@ -3,10 +3,9 @@ package main
 import (
 	"fmt"
 	"image"
+	"image/color"
 	"image/draw"
 	"math"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -17,31 +16,93 @@ func renderFocus(dst draw.Image, x, y int, id string) error {
 		return fmt.Errorf("focus id \"" + id + "\" not found")
 	}
 
-	if !f.AllowBranch {
+	if !isFocusShown(f) {
 		return nil
 	}
 
+	if searchFilterMode == SearchFilterDim && !hasSearchFilter(f) {
+		defer dimFocus(dst, x, y)
+	}
+
+	if gui.Item == nil {
+		return fmt.Errorf("national_focus_item gui element not found")
+	}
+
+	// Draw focus item elements in the order they are declared.
+	// Extra elements added by mods are drawn as is, game ones are only shown in some of the focus states.
+	itemSize := image.Point{gui.NationalFocusItem.Width, gui.NationalFocusItem.Height}
+	for _, e := range gui.Item.Children {
+		var err error
+		switch strings.ToLower(e.Name) {
+		case "bg":
+			err = renderFocusBG(dst, x, y, f)
+		case "symbol":
+			err = renderFocusSymbol(dst, x, y, f)
+		case "name":
+			renderFocusName(dst, x, y, f)
+		default:
+			if isBaseGUIElement("national_focus_item/" + e.Name) {
+				continue
+			}
+			err = renderGUIElement(dst, x, y, itemSize, e, nil, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if isDurationRenderingOn {
+		renderDurationBadge(dst, x, y, f)
+	}
+
+	if isSearchFilterIconsOn {
+		err := renderSearchFilterIcons(dst, x, y, f)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func renderFocusBG(dst draw.Image, x, y int, f Focus) error {
 	// Original game uses "GFX_technology_unavailable_item_bg" for some reason and replaces it with "GFX_focus_unavailable" via hardcoded part.
 	s := gfxMap["GFX_focus_unavailable"]
 	if len(f.Prerequisite) == 0 && f.Available {
 		s = gfxMap["GFX_focus_can_start"]
 	}
 
-	err := renderSprite(dst, x+gui.BG.Position.X, y+gui.BG.Position.Y, gui.BG.Orientation, gui.BG.CenterPosition, s)
+	frame := gui.BG.Frame
+	if bgStateFrame > 0 {
+		frame = bgStateFrame
+	}
+
+	err := renderSprite(dst, x+gui.BG.Position.X, y+gui.BG.Position.Y, gui.BG.Orientation, gui.BG.CenterPosition, s, frame)
 	if err != nil {
 		return fmt.Errorf("%v: %v", s.TextureFile, err)
 	}
+	return nil
+}
 
+func renderFocusSymbol(dst draw.Image, x, y int, f Focus) error {
 	symbol, ok := gfxMap[f.Icon]
 	if !ok {
 		symbol = gfxMap["GFX_goal_unknown"]
 	}
 
-	err = renderSprite(dst, x+gui.Symbol.Position.X, y+gui.Symbol.Position.Y, gui.Symbol.Orientation, gui.Symbol.CenterPosition, symbol)
+	frame := gui.Symbol.Frame
+	if n, ok := focusFrameOverrides[f.ID]; ok {
+		frame = n
+	}
+
+	err := renderSprite(dst, x+gui.Symbol.Position.X, y+gui.Symbol.Position.Y, gui.Symbol.Orientation, gui.Symbol.CenterPosition, symbol, frame)
 	if err != nil {
 		return fmt.Errorf("%v: %v", symbol.TextureFile, err)
 	}
+	return nil
+}
 
+func renderFocusName(dst draw.Image, x, y int, f Focus) {
 	text := f.Text
 	if text == "" {
 		text = f.ID
@@ -56,42 +117,396 @@ func renderFocus(dst draw.Image, x, y int, id string) error {
 		textY += gui.Name.MaxHeight / 2
 	}
 
-	font.RenderTextBox(dst, textX, textY, gui.Name.MaxWidth+2, gui.Name.MaxHeight, true, true, locMap[language][text].Value)
+	name, _ := locValue(text)
+	font.RenderTextBox(dst, textX, textY, gui.Name.MaxWidth+2, gui.Name.MaxHeight, true, true, name)
+}
+
+// renderDurationBadge draws focus duration in days in the top right corner of the focus.
+func renderDurationBadge(dst draw.Image, x, y int, f Focus) {
+	w := gui.NationalFocusItem.Width / 2
+	h := gui.Name.MaxHeight
+	if h == 0 {
+		h = 16
+	}
+	r := image.Rect(x+gui.NationalFocusItem.Width-w, y, x+gui.NationalFocusItem.Width, y+h)
+	draw.Draw(dst, r, &image.Uniform{color.RGBA{0, 0, 0, 160}}, image.ZP, draw.Over)
+	font.RenderTextBox(dst, r.Min.X+w/2, r.Min.Y+h/2, w, h, true, true, strconv.Itoa(focusDays(f))+" d")
+}
+
+// renderSearchFilterIcons draws search filter icons in a row in the top left corner of the focus.
+func renderSearchFilterIcons(dst draw.Image, x, y int, f Focus) error {
+	for _, filter := range f.SearchFilters {
+		// Filters without icons are reported by printUnresolvedSearchFilters.
+		s, err := searchFilterIcon(filter)
+		if err != nil {
+			continue
+		}
+
+		err = s.readTexture()
+		if err != nil {
+			return fmt.Errorf("%v: %v", s.TextureFile, err)
+		}
+		img, err := s.frameImage(1)
+		if err != nil {
+			return err
+		}
 
+		draw.Draw(dst, image.Rectangle{image.Point{x, y}, image.Point{x + img.Bounds().Dx(), y + img.Bounds().Dy()}}, img, img.Bounds().Min, draw.Over)
+		x += img.Bounds().Dx()
+	}
 	return nil
 }
 
-func renderSprite(dst draw.Image, x, y int, orientation, centerPosition string, sprite SpriteType) error {
+// dimFocus darkens focus that doesn't match selected search filters.
+func dimFocus(dst draw.Image, x, y int) {
+	r := image.Rect(x, y, x+gui.NationalFocusItem.Width, y+gui.NationalFocusItem.Height)
+	draw.Draw(dst, r, &image.Uniform{color.RGBA{0, 0, 0, 160}}, image.ZP, draw.Over)
+}
+
+// continuousFocusPanelSize returns size of the panel with continuous focus icons and names.
+func continuousFocusPanelSize(p ContinuousFocusPalette) (image.Point, error) {
+	var size image.Point
+	for _, cf := range p.Focuses {
+		icon, err := continuousFocusIcon(cf)
+		if err != nil {
+			return size, err
+		}
+		if icon.Bounds().Dx() > size.X {
+			size.X = icon.Bounds().Dx()
+		}
+		size.Y += icon.Bounds().Dy()
+	}
+	size.X += gui.Name.MaxWidth + 2
+	return size, nil
+}
+
+// renderContinuousFocuses draws continuous focus palette as a column of icons with names next to them.
+func renderContinuousFocuses(dst draw.Image, x, y int, p ContinuousFocusPalette) error {
+	size, err := continuousFocusPanelSize(p)
+	if err != nil {
+		return err
+	}
+	draw.Draw(dst, image.Rect(x, y, x+size.X, y+size.Y), &image.Uniform{color.RGBA{0, 0, 0, 160}}, image.ZP, draw.Over)
+
+	iconWidth := size.X - gui.Name.MaxWidth - 2
+	for _, cf := range p.Focuses {
+		icon, err := continuousFocusIcon(cf)
+		if err != nil {
+			return err
+		}
+		b := icon.Bounds()
+		draw.Draw(dst, image.Rectangle{image.Point{x, y}, image.Point{x + b.Dx(), y + b.Dy()}}, icon, b.Min, draw.Over)
+		name, _ := locValue(cf.ID)
+		font.RenderTextBox(dst, x+iconWidth+gui.Name.MaxWidth/2, y+b.Dy()/2, gui.Name.MaxWidth+2, b.Dy(), true, true, name)
+		y += b.Dy()
+	}
+	return nil
+}
+
+func continuousFocusIcon(cf ContinuousFocus) (image.Image, error) {
+	icon, ok := gfxMap[cf.Icon]
+	if !ok {
+		icon = gfxMap["GFX_goal_unknown"]
+	}
+	err := icon.readTexture()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", icon.TextureFile, err)
+	}
+	frame, ok := focusFrameOverrides[cf.ID]
+	if !ok {
+		frame = 1
+	}
+	return icon.frameImage(frame)
+}
+
+// renderInlayWindows draws focus tree inlay windows at their positions.
+func renderInlayWindows(dst draw.Image) error {
+	for _, ref := range focusTree.InlayWindows {
+		inlay, ok := inlayWindowMap[ref.ID]
+		if !ok {
+			return fmt.Errorf("inlay window \"" + ref.ID + "\" not found")
+		}
+		if !inlay.Visible {
+			continue
+		}
+		e := findGUIElement(inlay.WindowName)
+		if e == nil {
+			return fmt.Errorf("inlay window gui \"" + inlay.WindowName + "\" not found")
+		}
+		pos := focusTreePoint(ref.Position)
+		err := renderGUIElement(dst, pos.X, pos.Y, image.Point{}, e, inlay.ScriptedImages, inlay.HiddenButtons)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inlayWindowBounds returns the area covered by the focus tree inlay windows.
+func inlayWindowBounds() (image.Rectangle, error) {
+	var r image.Rectangle
+	for _, ref := range focusTree.InlayWindows {
+		e := findGUIElement(inlayWindowMap[ref.ID].WindowName)
+		if e == nil {
+			continue
+		}
+		w, err := e.ContainerWindowType()
+		if err != nil {
+			return r, err
+		}
+		origin := focusTreePoint(ref.Position).Add(w.Position)
+		r = r.Union(image.Rectangle{origin, origin.Add(image.Point{w.Width, w.Height})})
+	}
+	return r, nil
+}
+
+// printGUIWarnings prints gui element problems found during rendering.
+func printGUIWarnings() {
+	printWarnings(guiWarnings)
+}
+
+// renderGUIElement draws element sprite or text, containers are drawn with all of their children.
+// x and y are the position of the parent element, parentSize is used for element orientation.
+// sprites replaces sprites of the elements with the same name, hidden elements are not drawn.
+func renderGUIElement(dst draw.Image, x, y int, parentSize image.Point, e *GUIElement, sprites map[string]string, hidden []string) error {
+	if containsString(hidden, e.Name) {
+		return nil
+	}
+
+	pos, err := e.Point("position")
+	if err != nil {
+		return err
+	}
+	pos = pos.Add(orientationOffset(e.String("orientation"), parentSize))
+	x += pos.X
+	y += pos.Y
+
+	switch e.Type {
+	case "icontype", "buttontype":
+		name := e.String("spritetype")
+		if name == "" {
+			name = e.String("quadtexturesprite")
+		}
+		if s, ok := sprites[e.Name]; ok {
+			name = s
+		}
+		if name == "" {
+			return nil
+		}
+		sprite, ok := gfxMap[name]
+		if !ok {
+			guiWarnings["sprite \""+name+"\" of gui element \""+e.Name+"\" not found"] = true
+			return nil
+		}
+		frame, err := e.Int("frame")
+		if err != nil {
+			return err
+		}
+		size, err := e.Size()
+		if err != nil {
+			return err
+		}
+		return renderSpriteFrame(dst, x, y, sprite, frame, size, strings.ToLower(e.String("centerposition")) == "yes")
+
+	case "instanttextboxtype", "textboxtype":
+		t, err := e.InstantTextboxType()
+		if err != nil {
+			return err
+		}
+		f, err := initFont(t.Font)
+		if err != nil {
+			return err
+		}
+		text, ok := locValue(t.Text)
+		if !ok {
+			text = resolveLocReferences(t.Text, nil)
+		}
+
+		if strings.ToLower(t.Format) == "center" {
+			x += t.MaxWidth / 2
+		}
+		if strings.ToLower(t.VerticalAlignment) == "center" {
+			y += t.MaxHeight / 2
+		}
+		f.RenderTextBox(dst, x, y, t.MaxWidth+2, t.MaxHeight, true, true, text)
+
+	case "containerwindowtype", "windowtype":
+		w, err := e.ContainerWindowType()
+		if err != nil {
+			return err
+		}
+		if name := e.String("background.spritetype") + e.String("background.quadtexturesprite"); name != "" {
+			sprite, ok := gfxMap[name]
+			if !ok {
+				guiWarnings["sprite \""+name+"\" of gui element \""+e.Name+"\" not found"] = true
+			} else {
+				err := renderSpriteFrame(dst, x, y, sprite, 0, image.Point{w.Width, w.Height}, false)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for _, child := range e.Children {
+			err := renderGUIElement(dst, x, y, image.Point{w.Width, w.Height}, child, sprites, hidden)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// orientationOffset returns the point of the parent element that child position is relative to.
+func orientationOffset(orientation string, parentSize image.Point) image.Point {
+	switch strings.ToLower(orientation) {
+	case "upper_right":
+		return image.Point{parentSize.X, 0}
+	case "lower_left":
+		return image.Point{0, parentSize.Y}
+	case "lower_right":
+		return parentSize
+	case "center":
+		return parentSize.Div(2)
+	case "center_up":
+		return image.Point{parentSize.X / 2, 0}
+	case "center_down":
+		return image.Point{parentSize.X / 2, parentSize.Y}
+	case "center_left":
+		return image.Point{0, parentSize.Y / 2}
+	case "center_right":
+		return image.Point{parentSize.X, parentSize.Y / 2}
+	}
+	return image.Point{}
+}
+
+// renderSpriteFrame draws sprite with its upper left corner at x, y, or its center if centered is true.
+// Frame 0 draws the first frame of the multi-frame sprites, size is used by corneredTileSpriteType.
+func renderSpriteFrame(dst draw.Image, x, y int, sprite SpriteType, frame int, size image.Point, centered bool) error {
+	err := sprite.readTexture()
+	if err != nil {
+		return fmt.Errorf("%v: %v", sprite.TextureFile, err)
+	}
+	img, err := sprite.frameImage(frame)
+	if err != nil {
+		return err
+	}
+	img = fitSprite(sprite, img, size)
+	b := img.Bounds()
+	if centered {
+		x -= b.Dx() / 2
+		y -= b.Dy() / 2
+	}
+	draw.Draw(dst, image.Rectangle{image.Point{x, y}, image.Point{x + b.Dx(), y + b.Dy()}}, img, b.Min, draw.Over)
+	return nil
+}
+
+func renderSprite(dst draw.Image, x, y int, orientation, centerPosition string, sprite SpriteType, frame int) error {
 	// Read image data.
 	err := sprite.readTexture()
 	if err != nil {
 		return err
 	}
 
+	img, err := sprite.frameImage(frame)
+	if err != nil {
+		return err
+	}
+	img = fitSprite(sprite, img, image.Point{})
+
 	if strings.ToLower(orientation) == "center" {
 		x += gui.NationalFocusItem.Width / 2
 		y += gui.NationalFocusItem.Height / 2
 	}
 
 	if strings.ToLower(centerPosition) == "yes" {
-		x -= sprite.Image.Bounds().Max.X / 2
-		y -= sprite.Image.Bounds().Max.Y / 2
+		x -= img.Bounds().Dx() / 2
+		y -= img.Bounds().Dy() / 2
 	}
 
-	draw.Draw(dst, image.Rectangle{image.Point{x, y}, image.Point{x + sprite.Image.Bounds().Max.X, y + sprite.Image.Bounds().Max.Y}}, sprite.Image, image.ZP, draw.Over)
+	draw.Draw(dst, image.Rectangle{image.Point{x, y}, image.Point{x + img.Bounds().Dx(), y + img.Bounds().Dy()}}, img, img.Bounds().Min, draw.Over)
 
 	return nil
 }
 
+// fitSprite returns corneredTileSpriteType image resized to size, or to the sprite size if size is empty.
+// Other sprite types are returned as is.
+func fitSprite(sprite SpriteType, img image.Image, size image.Point) image.Image {
+	if sprite.Type != "corneredtilespritetype" {
+		return img
+	}
+	if size.X <= 0 || size.Y <= 0 {
+		size = sprite.Size
+	}
+	if size.X <= 0 || size.Y <= 0 {
+		return img
+	}
+	dst := image.NewRGBA(image.Rectangle{image.ZP, size})
+	renderCorneredTile(dst, dst.Bounds(), img, sprite.BorderSize, sprite.TilingCenter)
+	return dst
+}
+
+// renderCorneredTile draws nine-slice image into r.
+// Corners are drawn as is, edges and center are stretched, or tiled if tile is true.
+func renderCorneredTile(dst draw.Image, r image.Rectangle, src image.Image, border image.Point, tile bool) {
+	// Source keeps at least one pixel between the borders, so the middle is not left empty.
+	sb := src.Bounds()
+	border.X = minInt(border.X, (sb.Dx()-1)/2, r.Dx()/2)
+	border.Y = minInt(border.Y, (sb.Dy()-1)/2, r.Dy()/2)
+
+	srcX := []int{sb.Min.X, sb.Min.X + border.X, sb.Max.X - border.X, sb.Max.X}
+	srcY := []int{sb.Min.Y, sb.Min.Y + border.Y, sb.Max.Y - border.Y, sb.Max.Y}
+	dstX := []int{r.Min.X, r.Min.X + border.X, r.Max.X - border.X, r.Max.X}
+	dstY := []int{r.Min.Y, r.Min.Y + border.Y, r.Max.Y - border.Y, r.Max.Y}
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			sr := image.Rect(srcX[i], srcY[j], srcX[i+1], srcY[j+1])
+			dr := image.Rect(dstX[i], dstY[j], dstX[i+1], dstY[j+1])
+			switch {
+			case sr.Empty() || dr.Empty():
+			case i != 1 && j != 1:
+				draw.Draw(dst, dr, src, sr.Min, draw.Over)
+			case tile:
+				drawTiled(dst, dr, src, sr)
+			default:
+				drawStretched(dst, dr, src, sr)
+			}
+		}
+	}
+}
+
+// drawTiled repeats sr part of src to fill dr.
+func drawTiled(dst draw.Image, dr image.Rectangle, src image.Image, sr image.Rectangle) {
+	for y := dr.Min.Y; y < dr.Max.Y; y += sr.Dy() {
+		for x := dr.Min.X; x < dr.Max.X; x += sr.Dx() {
+			tile := image.Rect(x, y, x+sr.Dx(), y+sr.Dy()).Intersect(dr)
+			draw.Draw(dst, tile, src, sr.Min, draw.Over)
+		}
+	}
+}
+
+// drawStretched scales sr part of src to dr using nearest neighbour sampling.
+func drawStretched(dst draw.Image, dr image.Rectangle, src image.Image, sr image.Rectangle) {
+	scaled := image.NewRGBA(dr)
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		sy := sr.Min.Y + (y-dr.Min.Y)*sr.Dy()/dr.Dy()
+		for x := dr.Min.X; x < dr.Max.X; x++ {
+			sx := sr.Min.X + (x-dr.Min.X)*sr.Dx()/dr.Dx()
+			scaled.Set(x, y, src.At(sx, sy))
+		}
+	}
+	draw.Draw(dst, dr, scaled, dr.Min, draw.Over)
+}
+
 func renderExclusiveLines(dst *image.RGBA) error {
 	for _, f1 := range focusMap {
-		if !f1.AllowBranch {
+		if !isFocusShown(f1) {
 			continue
 		}
 	OUTER:
 		for _, e1 := range f1.MutuallyExclusive {
 			f2 := focusMap[e1]
-			if !f2.AllowBranch {
+			if !isFocusShown(f2) {
 				continue
 			}
 
@@ -243,7 +658,7 @@ func renderLines(dst *image.RGBA) error {
 
 	var drawnCoords []image.Point
 	for _, p := range focusMap {
-		if len(p.Children) > 0 && p.AllowBranch {
+		if len(p.Children) > 0 && isFocusShown(p) {
 			x := p.X*gui.FocusSpacing.X + gui.NationalFocusLink.Position.X + gui.LinkBegin.X + gui.LinkOffsets.X + spacingX
 			y := p.Y*gui.FocusSpacing.Y + gui.NationalFocusLink.Position.Y + gui.LinkBegin.Y + gui.LinkOffsets.Y + spacingY - 16
 
@@ -276,7 +691,7 @@ func renderLines(dst *image.RGBA) error {
 			cornerXvalues := []int{x}
 			for _, c := range p.Children {
 				c := focusMap[c.ID]
-				if c.AllowBranch {
+				if isFocusShown(c) {
 					cornerXvalues = append(cornerXvalues, c.X*gui.FocusSpacing.X+gui.NationalFocusLink.Position.X+gui.LinkBegin.X+gui.LinkOffsets.X+spacingX)
 				}
 			}
@@ -284,7 +699,7 @@ func renderLines(dst *image.RGBA) error {
 			var isPrevSolid bool
 			for _, c := range p.Children {
 				c := focusMap[c.ID]
-				if !c.AllowBranch {
+				if !isFocusShown(c) {
 					continue
 				}
 
@@ -410,31 +825,28 @@ func readTextureAndGetFrames(texture string, frame1, frame2 int) (image.Image, i
 }
 
 func (s *SpriteType) readTexture() error {
-	imgFile, err := os.Open(s.TextureFile)
+	path, err := findTexture(s.TextureFile)
 	if err != nil {
-		// Try looking for the sprite in other declared mod/game folders.
-		texture := s.TextureFile
-		for _, p := range modPaths {
-			texture = strings.TrimPrefix(texture, p)
-		}
-
-		for i := len(modPaths) - 1; i >= 0; i-- {
-			imgFile, err = os.Open(filepath.Join(modPaths[i], texture))
-			if err == nil {
-				goto TextureFileFound
-			}
-		}
-
 		return err
 	}
-TextureFileFound:
-	defer imgFile.Close()
+	s.Image, err = loadTexture(path)
+	s.texturePath = path
+	return err
+}
 
-	s.Image, _, err = image.Decode(imgFile)
-	if err != nil {
-		return err
+// frameImage returns the frame of the multi-frame sprite, or the whole image for single frame ones.
+// Frame 0 is the first frame.
+func (s *SpriteType) frameImage(f int) (image.Image, error) {
+	if s.NoOfFrames <= 1 {
+		if s.Image == nil {
+			return nil, fmt.Errorf(s.Name + " has no image data")
+		}
+		return s.Image, nil
 	}
-	return nil
+	if f < 1 {
+		f = 1
+	}
+	return s.getFrame(f)
 }
 
 func (s *SpriteType) getFrame(f int) (image.Image, error) {
@@ -444,8 +856,21 @@ func (s *SpriteType) getFrame(f int) (image.Image, error) {
 	if f < 1 {
 		return nil, fmt.Errorf("frame number must be higher then 0, it is currently " + strconv.Itoa(f))
 	}
+	if s.NoOfFrames > 0 && f > s.NoOfFrames {
+		return nil, fmt.Errorf(s.Name + " has only " + strconv.Itoa(s.NoOfFrames) + " frames, frame " + strconv.Itoa(f) + " requested")
+	}
+	// Sprites with the same name in different mods can use different textures or frame counts.
+	key := fmt.Sprintf("%v:%v:%v", s.texturePath, s.NoOfFrames, f)
+	if s.texturePath != "" {
+		if img, ok := frameCache.get(key); ok {
+			return img, nil
+		}
+	}
 	frameSize := image.Point{s.Image.Bounds().Max.X / s.NoOfFrames, s.Image.Bounds().Max.Y}
 	dst := image.NewRGBA(image.Rectangle{image.ZP, frameSize})
 	draw.Draw(dst, dst.Bounds(), s.Image, image.Point{frameSize.X * (f - 1), 0}, draw.Src)
+	if s.texturePath != "" {
+		frameCache.add(key, dst)
+	}
 	return dst, nil
 }
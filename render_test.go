@@ -0,0 +1,165 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writePNG saves img into the test temporary folder and returns its path.
+func writePNG(t *testing.T, name string, img image.Image) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = png.Encode(f, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRenderInlayWindows(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	draw.Draw(src, src.Bounds(), &image.Uniform{red}, image.ZP, draw.Src)
+
+	gfxMap = map[string]SpriteType{"GFX_red": {Name: "GFX_red", TextureFile: writePNG(t, "red.png", src)}}
+	guiElementMap = map[string]*GUIElement{
+		"inlay_window": {
+			Type: "containerwindowtype",
+			Name: "inlay_window",
+			Properties: map[string]string{
+				"size.width":  "10",
+				"size.height": "10",
+			},
+			Children: []*GUIElement{
+				{Type: "icontype", Name: "missing", Properties: map[string]string{"spritetype": "GFX_missing"}},
+				{Type: "icontype", Name: "icon", Properties: map[string]string{"spritetype": "GFX_red", "position.x": "3", "position.y": "4"}},
+			},
+		},
+	}
+	inlayWindowMap = map[string]InlayWindow{"inlay": {ID: "inlay", WindowName: "inlay_window", Visible: true}}
+	focusTree = FocusTree{InlayWindows: []InlayWindowRef{{ID: "inlay", Position: image.Point{5, 6}}}}
+	focusTreeShift = image.Point{1, 0}
+	gui.FocusSpacing = image.Point{20, 30}
+	defer func() {
+		gfxMap = make(map[string]SpriteType)
+		guiElementMap = make(map[string]*GUIElement)
+		inlayWindowMap = make(map[string]InlayWindow)
+		focusTree = FocusTree{}
+		focusTreeShift = image.Point{}
+		gui = FocusGUI{}
+		guiWarnings = make(map[string]bool)
+	}()
+
+	bounds, err := inlayWindowBounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin := image.Point{20 + spacingX + 5, spacingY + 6}
+	if want := (image.Rectangle{origin, origin.Add(image.Point{10, 10})}); bounds != want {
+		t.Errorf("got bounds %v, want %v", bounds, want)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Max.X, bounds.Max.Y))
+	err = renderInlayWindows(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !guiWarnings["sprite \"GFX_missing\" of gui element \"missing\" not found"] {
+		t.Errorf("missing sprite is not reported, got warnings %v", guiWarnings)
+	}
+	icon := origin.Add(image.Point{3, 4})
+	if c := dst.RGBAAt(icon.X, icon.Y); c != red {
+		t.Errorf("got %v at the icon position, want %v", c, red)
+	}
+	if c := dst.RGBAAt(icon.X-1, icon.Y); c.A != 0 {
+		t.Errorf("got %v next to the icon, want transparent", c)
+	}
+}
+
+func TestRenderCorneredTile(t *testing.T) {
+	// Every source pixel has its own color, so destination pixels tell where they are copied from.
+	src := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			src.SetRGBA(x, y, color.RGBA{uint8(x * 50), uint8(y * 50), 0, 255})
+		}
+	}
+
+	tests := []struct {
+		name   string
+		size   image.Point
+		border image.Point
+		tile   bool
+		// Source columns and rows of the destination ones.
+		srcX []int
+		srcY []int
+	}{
+		{"same size", image.Point{4, 4}, image.Point{1, 1}, false, []int{0, 1, 2, 3}, []int{0, 1, 2, 3}},
+		{"stretched", image.Point{6, 5}, image.Point{1, 1}, false, []int{0, 1, 1, 2, 2, 3}, []int{0, 1, 1, 2, 3}},
+		{"tiled", image.Point{6, 5}, image.Point{1, 1}, true, []int{0, 1, 2, 1, 2, 3}, []int{0, 1, 2, 1, 3}},
+		{"border leaves source middle", image.Point{6, 4}, image.Point{2, 0}, false, []int{0, 1, 1, 2, 2, 3}, []int{0, 1, 2, 3}},
+		{"border is clamped to the size", image.Point{2, 3}, image.Point{3, 3}, false, []int{0, 3}, []int{0, 1, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := image.NewRGBA(image.Rect(0, 0, tt.size.X+2, tt.size.Y+2))
+			r := image.Rectangle{image.Point{1, 1}, image.Point{1, 1}.Add(tt.size)}
+			renderCorneredTile(dst, r, src, tt.border, tt.tile)
+			for y, sy := range tt.srcY {
+				for x, sx := range tt.srcX {
+					got := dst.RGBAAt(r.Min.X+x, r.Min.Y+y)
+					if want := src.RGBAAt(sx, sy); got != want {
+						t.Errorf("pixel %v, %v is %v, want %v", x, y, got, want)
+					}
+				}
+			}
+			if c := dst.RGBAAt(r.Max.X, r.Max.Y); c.A != 0 {
+				t.Errorf("pixel outside of the rectangle is drawn")
+			}
+		})
+	}
+}
+
+func TestGetFrameCache(t *testing.T) {
+	// Sprites with the same name from different mods, with different textures and frame counts.
+	red := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	draw.Draw(red, red.Bounds(), &image.Uniform{color.RGBA{255, 0, 0, 255}}, image.ZP, draw.Src)
+	blue := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	draw.Draw(blue, blue.Bounds(), &image.Uniform{color.RGBA{0, 0, 255, 255}}, image.ZP, draw.Src)
+	redPath := writePNG(t, "red.png", red)
+	sprites := []SpriteType{
+		{Name: "GFX_frames", TextureFile: redPath, NoOfFrames: 2},
+		{Name: "GFX_frames", TextureFile: writePNG(t, "blue.png", blue), NoOfFrames: 2},
+		{Name: "GFX_frames", TextureFile: redPath, NoOfFrames: 4},
+	}
+	defer frameCache.reset()
+	defer textureCache.reset()
+
+	for i, want := range []struct {
+		width int
+		c     color.RGBA
+	}{{2, color.RGBA{255, 0, 0, 255}}, {2, color.RGBA{0, 0, 255, 255}}, {1, color.RGBA{255, 0, 0, 255}}} {
+		s := sprites[i]
+		err := s.readTexture()
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := s.getFrame(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds().Dx() != want.width || color.RGBAModel.Convert(img.At(0, 0)) != want.c {
+			t.Errorf("sprite %v: got frame %v wide with %v, want %v wide with %v", i, img.Bounds().Dx(), img.At(0, 0), want.width, want.c)
+		}
+	}
+}
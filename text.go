@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// textFont is a bitmap font loaded from BMFont .fnt file and its texture pages.
+type textFont struct {
+	LineHeight int
+	Base       int
+	Glyphs     map[rune]fontGlyph
+	Kernings   map[[2]rune]int
+	Pages      []image.Image
+}
+
+type fontGlyph struct {
+	Rect    image.Rectangle
+	Offset  image.Point
+	Advance int
+	Page    int
+}
+
+// defaultTextColors are used for § color codes if textcolors are not defined in gfx files.
+var defaultTextColors = map[string]color.NRGBA{
+	"W": {255, 255, 255, 255},
+	"B": {0, 0, 255, 255},
+	"G": {0, 159, 3, 255},
+	"R": {255, 50, 50, 255},
+	"b": {0, 0, 0, 255},
+	"g": {176, 176, 176, 255},
+	"Y": {255, 189, 0, 255},
+	"H": {255, 189, 0, 255},
+	"T": {255, 255, 255, 255},
+	"O": {255, 112, 25, 255},
+	"L": {195, 176, 145, 255},
+	"C": {35, 206, 255, 255},
+	"t": {185, 240, 185, 255},
+}
+
+// textColor returns color of § color code.
+func textColor(code string) (color.NRGBA, bool) {
+	if c, ok := textColors[code]; ok {
+		return c, true
+	}
+	c, ok := defaultTextColors[code]
+	return c, ok
+}
+
+// loadTextFont reads BMFont file in text or binary format.
+// Single page fonts use the texture named after the font file, as the game does.
+func loadTextFont(path string) (*textFont, error) {
+	b, err := ioutil.ReadFile(path + ".fnt")
+	if err != nil {
+		return nil, err
+	}
+
+	f := &textFont{Glyphs: make(map[rune]fontGlyph), Kernings: make(map[[2]rune]int)}
+	var pageFiles []string
+	var pageIDs []int
+	if bytes.HasPrefix(b, []byte("BMF")) {
+		pageFiles, pageIDs, err = f.parseBinary(b)
+	} else {
+		pageFiles, pageIDs, err = f.parseText(string(b))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v.fnt: %v", path, err)
+	}
+
+	// Glyphs refer to pages by their position in the Pages slice.
+	pages := make(map[int]int)
+	for i, file := range pageFiles {
+		texture := path + ".dds"
+		if len(pageFiles) > 1 {
+			texture = filepath.Join(filepath.Dir(path), file)
+		}
+		texture, err = findTexture(texture)
+		if err != nil {
+			return nil, err
+		}
+		img, err := loadTexture(texture)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", texture, err)
+		}
+		pages[pageIDs[i]] = len(f.Pages)
+		f.Pages = append(f.Pages, img)
+	}
+	for r, g := range f.Glyphs {
+		page, ok := pages[g.Page]
+		if !ok {
+			delete(f.Glyphs, r)
+			continue
+		}
+		g.Page = page
+		f.Glyphs[r] = g
+	}
+	return f, nil
+}
+
+func (f *textFont) parseText(s string) (pageFiles []string, pageIDs []int, err error) {
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		values := make(map[string]string)
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) == 2 {
+				values[kv[0]] = trimQuotes(kv[1])
+			}
+		}
+		n := func(key string) int {
+			v, _ := strconv.Atoi(values[key])
+			return v
+		}
+
+		switch fields[0] {
+		case "common":
+			f.LineHeight, f.Base = n("lineHeight"), n("base")
+		case "page":
+			// File names with spaces are cut by fields.
+			file := values["file"]
+			if i := strings.Index(line, "file=\""); i >= 0 {
+				file = line[i+len("file=\""):]
+				file = file[:strings.IndexByte(file+"\"", '"')]
+			}
+			pageIDs = append(pageIDs, n("id"))
+			pageFiles = append(pageFiles, file)
+		case "char":
+			f.Glyphs[rune(n("id"))] = fontGlyph{
+				Rect:    image.Rect(n("x"), n("y"), n("x")+n("width"), n("y")+n("height")),
+				Offset:  image.Point{n("xoffset"), n("yoffset")},
+				Advance: n("xadvance"),
+				Page:    n("page"),
+			}
+		case "kerning":
+			f.Kernings[[2]rune{rune(n("first")), rune(n("second"))}] = n("amount")
+		}
+	}
+	if len(pageFiles) == 0 {
+		return nil, nil, fmt.Errorf("no font pages defined")
+	}
+	return pageFiles, pageIDs, nil
+}
+
+func (f *textFont) parseBinary(b []byte) (pageFiles []string, pageIDs []int, err error) {
+	le := binary.LittleEndian
+	if len(b) < 4 || b[3] != 3 {
+		return nil, nil, fmt.Errorf("unsupported binary font version")
+	}
+	for i := 4; i+5 <= len(b); {
+		blockType, size := b[i], int(le.Uint32(b[i+1:]))
+		i += 5
+		if i+size > len(b) {
+			return nil, nil, fmt.Errorf("binary font block is too short")
+		}
+		block := b[i : i+size]
+		i += size
+
+		switch blockType {
+		case 2:
+			if len(block) < 4 {
+				return nil, nil, fmt.Errorf("binary font common block is too short")
+			}
+			f.LineHeight, f.Base = int(le.Uint16(block)), int(le.Uint16(block[2:]))
+		case 3:
+			for id, file := range strings.Split(strings.TrimRight(string(block), "\x00"), "\x00") {
+				pageIDs = append(pageIDs, id)
+				pageFiles = append(pageFiles, file)
+			}
+		case 4:
+			for c := 0; c+20 <= len(block); c += 20 {
+				ch := block[c:]
+				x, y := int(le.Uint16(ch[4:])), int(le.Uint16(ch[6:]))
+				f.Glyphs[rune(le.Uint32(ch))] = fontGlyph{
+					Rect:    image.Rect(x, y, x+int(le.Uint16(ch[8:])), y+int(le.Uint16(ch[10:]))),
+					Offset:  image.Point{int(int16(le.Uint16(ch[12:]))), int(int16(le.Uint16(ch[14:])))},
+					Advance: int(int16(le.Uint16(ch[16:]))),
+					Page:    int(ch[18]),
+				}
+			}
+		case 5:
+			for k := 0; k+10 <= len(block); k += 10 {
+				f.Kernings[[2]rune{rune(le.Uint32(block[k:])), rune(le.Uint32(block[k+4:]))}] = int(int16(le.Uint16(block[k+8:])))
+			}
+		}
+	}
+	if len(pageFiles) == 0 {
+		return nil, nil, fmt.Errorf("no font pages defined")
+	}
+	return pageFiles, pageIDs, nil
+}
+
+// addSubFont adds glyphs missing from the font.
+func (f *textFont) addSubFont(sub *textFont) {
+	for r, g := range sub.Glyphs {
+		if _, ok := f.Glyphs[r]; !ok {
+			g.Page += len(f.Pages)
+			f.Glyphs[r] = g
+		}
+	}
+	f.Pages = append(f.Pages, sub.Pages...)
+}
+
+// textItem is a positioned glyph or inline icon of the laid out text.
+type textItem struct {
+	Glyph fontGlyph
+	X     int
+	Color color.NRGBA
+	Tint  bool
+	Icon  image.Image
+}
+
+// isBlank reports if nothing is drawn for the item, like for spaces.
+func (t textItem) isBlank() bool {
+	return t.Icon == nil && t.Glyph.Rect.Empty()
+}
+
+type textLine struct {
+	Items []textItem
+	Width int
+}
+
+// textRun is a part of the text drawn in the same color.
+type textRun struct {
+	Text  string
+	Color color.NRGBA
+	Tint  bool
+}
+
+// splitColorCodes splits the text into runs by § color codes.
+// §X starts color X and §! returns to the previous one. Unknown codes keep the current color.
+func splitColorCodes(text string) []textRun {
+	var runs []textRun
+	var stack []textRun
+	current := textRun{}
+	var b strings.Builder
+	flush := func() {
+		if b.Len() > 0 {
+			runs = append(runs, textRun{b.String(), current.Color, current.Tint})
+			b.Reset()
+		}
+	}
+
+	rs := []rune(text)
+	for i := 0; i < len(rs); i++ {
+		if rs[i] != '§' {
+			b.WriteRune(rs[i])
+			continue
+		}
+		flush()
+		if i+1 >= len(rs) {
+			break
+		}
+		i++
+		if rs[i] == '!' {
+			if len(stack) > 0 {
+				current = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+		stack = append(stack, current)
+		if c, ok := textColor(string(rs[i])); ok {
+			current = textRun{Color: c, Tint: true}
+		}
+	}
+	flush()
+	return runs
+}
+
+// layout breaks the text into lines no wider than width, words are wrapped at spaces.
+// Both line breaks and literal \n in localisation start a new line.
+func (f *textFont) layout(text string, width int) []textLine {
+	text = strings.Replace(text, `\n`, "\n", -1)
+
+	lines := []textLine{{}}
+	var word []textItem
+	wordWidth := 0
+	pen := 0
+	var prev rune
+
+	breakLine := func() {
+		last := &lines[len(lines)-1]
+		last.Width = pen
+		lines = append(lines, textLine{})
+		pen = 0
+	}
+	flushWord := func() {
+		last := &lines[len(lines)-1]
+		if len(last.Items) > 0 && pen+wordWidth > width {
+			// Drop trailing space before wrapping.
+			for len(last.Items) > 0 && last.Items[len(last.Items)-1].isBlank() {
+				pen = last.Items[len(last.Items)-1].X
+				last.Items = last.Items[:len(last.Items)-1]
+			}
+			breakLine()
+			last = &lines[len(lines)-1]
+		}
+		for _, item := range word {
+			item.X += pen
+			last.Items = append(last.Items, item)
+		}
+		pen += wordWidth
+		word = word[:0]
+		wordWidth = 0
+	}
+
+	for _, run := range splitColorCodes(text) {
+		rs := []rune(run.Text)
+		for i := 0; i < len(rs); i++ {
+			r := rs[i]
+			switch r {
+			case '£':
+				name, frame, next := parseInlineIcon(rs, i+1)
+				i = next - 1
+				icon := inlineIcon(name, frame)
+				if icon == nil {
+					continue
+				}
+				// Icons are part of the word they are attached to.
+				word = append(word, textItem{X: wordWidth, Icon: icon})
+				wordWidth += icon.Bounds().Dx()
+				prev = 0
+				continue
+			case '\n':
+				flushWord()
+				breakLine()
+				prev = 0
+				continue
+			case '\t', '\r':
+				r = ' '
+			}
+			g, ok := f.Glyphs[r]
+			if !ok {
+				g, ok = f.Glyphs['?']
+				if !ok {
+					continue
+				}
+			}
+			kerning := f.Kernings[[2]rune{prev, r}]
+			prev = r
+
+			if r == ' ' {
+				flushWord()
+				lines[len(lines)-1].Items = append(lines[len(lines)-1].Items, textItem{fontGlyph{Advance: g.Advance}, pen + kerning, run.Color, run.Tint, nil})
+				pen += kerning + g.Advance
+				continue
+			}
+			word = append(word, textItem{g, wordWidth + kerning, run.Color, run.Tint, nil})
+			wordWidth += kerning + g.Advance
+		}
+	}
+	flushWord()
+	lines[len(lines)-1].Width = pen
+
+	// Trailing spaces do not count towards the line width.
+	for i := range lines {
+		items := lines[i].Items
+		for len(items) > 0 && items[len(items)-1].isBlank() {
+			lines[i].Width = items[len(items)-1].X
+			items = items[:len(items)-1]
+		}
+		lines[i].Items = items
+	}
+	return lines
+}
+
+// RenderTextBox draws text wrapped to w width.
+// If centerX or centerY is set, text is centered around x or y respectively, otherwise x and y are the top left corner.
+func (f *textFont) RenderTextBox(dst draw.Image, x, y, w, h int, centerX, centerY bool, text string) {
+	lines := f.layout(text, w)
+	top := y
+	if centerY {
+		top -= len(lines) * f.LineHeight / 2
+	}
+	for i, line := range lines {
+		left := x
+		if centerX {
+			left -= line.Width / 2
+		}
+		for _, item := range line.Items {
+			if item.Icon != nil {
+				// Icons stand on the baseline.
+				b := item.Icon.Bounds()
+				p := image.Point{left + item.X, top + i*f.LineHeight + f.Base - b.Dy()}
+				draw.Draw(dst, image.Rectangle{p, p.Add(b.Size())}, item.Icon, b.Min, draw.Over)
+				continue
+			}
+			g := item.Glyph
+			if g.Rect.Empty() {
+				continue
+			}
+			p := image.Point{left + item.X + g.Offset.X, top + i*f.LineHeight + g.Offset.Y}
+			r := image.Rectangle{p, p.Add(g.Rect.Size())}
+			if item.Tint {
+				drawTinted(dst, r, f.Pages[g.Page], g.Rect.Min, item.Color)
+			} else {
+				draw.Draw(dst, r, f.Pages[g.Page], g.Rect.Min, draw.Over)
+			}
+		}
+	}
+}
+
+// parseInlineIcon reads £name, £name|frame and £name£ icon references, i points after £.
+// next is the position after the reference.
+func parseInlineIcon(rs []rune, i int) (name string, frame, next int) {
+	start := i
+	for i < len(rs) && (unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i]) || rs[i] == '_') {
+		i++
+	}
+	name = string(rs[start:i])
+	frame = 1
+	if i+1 < len(rs) && rs[i] == '|' && unicode.IsDigit(rs[i+1]) {
+		i++
+		frame = 0
+		for i < len(rs) && unicode.IsDigit(rs[i]) {
+			frame = frame*10 + int(rs[i]-'0')
+			i++
+		}
+	}
+	if i < len(rs) && rs[i] == '£' {
+		i++
+	}
+	return name, frame, i
+}
+
+// inlineIconNames returns sprite names of inline icons used in the text, both with and without GFX_ prefix.
+func inlineIconNames(text string) []string {
+	var names []string
+	rs := []rune(text)
+	for i := 0; i < len(rs); i++ {
+		if rs[i] != '£' {
+			continue
+		}
+		name, _, next := parseInlineIcon(rs, i+1)
+		i = next - 1
+		if name == "" {
+			continue
+		}
+		names = append(names, name)
+		if !strings.HasPrefix(name, "GFX_") {
+			names = append(names, "GFX_"+name)
+		}
+	}
+	return names
+}
+
+// inlineIcon returns the frame of the sprite used as inline icon.
+// Missing icons are reported and nothing is drawn for them.
+func inlineIcon(name string, frame int) image.Image {
+	if name == "" {
+		return nil
+	}
+	s, ok := gfxMap["GFX_"+name]
+	if !ok {
+		s, ok = gfxMap[name]
+	}
+	if !ok {
+		locWarnings["inline icon \"£"+name+"\" not found"] = true
+		return nil
+	}
+	err := s.readTexture()
+	if err != nil {
+		locWarnings["inline icon \"£"+name+"\": "+err.Error()] = true
+		return nil
+	}
+	img, err := s.frameImage(frame)
+	if err != nil {
+		locWarnings["inline icon \"£"+name+"\": "+err.Error()] = true
+		return nil
+	}
+	return img
+}
+
+// drawTinted draws src multiplied by tint color.
+func drawTinted(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point, tint color.NRGBA) {
+	tinted := image.NewNRGBA(image.Rectangle{image.ZP, r.Size()})
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			c := color.NRGBAModel.Convert(src.At(sp.X+x, sp.Y+y)).(color.NRGBA)
+			c.R = uint8(int(c.R) * int(tint.R) / 255)
+			c.G = uint8(int(c.G) * int(tint.G) / 255)
+			c.B = uint8(int(c.B) * int(tint.B) / 255)
+			c.A = uint8(int(c.A) * int(tint.A) / 255)
+			tinted.SetNRGBA(x, y, c)
+		}
+	}
+	draw.Draw(dst, r, tinted, image.ZP, draw.Over)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"reflect"
+	"testing"
+)
+
+func TestSplitColorCodes(t *testing.T) {
+	textColors = map[string]color.NRGBA{"Q": {1, 2, 3, 255}}
+	defer func() { textColors = make(map[string]color.NRGBA) }()
+
+	red := defaultTextColors["R"]
+	green := defaultTextColors["G"]
+	tests := []struct {
+		name string
+		text string
+		want []textRun
+	}{
+		{"plain", "Focus", []textRun{{"Focus", color.NRGBA{}, false}}},
+		{"color", "§RRed§! text", []textRun{{"Red", red, true}, {" text", color.NRGBA{}, false}}},
+		{"nested", "a§Rb§Gc§!d§!e", []textRun{
+			{"a", color.NRGBA{}, false},
+			{"b", red, true},
+			{"c", green, true},
+			{"d", red, true},
+			{"e", color.NRGBA{}, false},
+		}},
+		{"unclosed", "§Rred", []textRun{{"red", red, true}}},
+		{"unknown code keeps color", "§Ra§Zb§!c§!d", []textRun{
+			{"a", red, true},
+			{"b", red, true},
+			{"c", red, true},
+			{"d", color.NRGBA{}, false},
+		}},
+		{"reset without color", "a§!b", []textRun{{"a", color.NRGBA{}, false}, {"b", color.NRGBA{}, false}}},
+		{"trailing sign", "a§", []textRun{{"a", color.NRGBA{}, false}}},
+		{"gfx defined color", "§Qa", []textRun{{"a", color.NRGBA{1, 2, 3, 255}, true}}},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitColorCodes(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitColorCodes(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextFontLayout(t *testing.T) {
+	f, err := loadTextFont("testdata/font")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Glyphs advance by 4, space by 2 and "?" by 3, "AB" is kerned by -1.
+	tests := []struct {
+		name   string
+		text   string
+		width  int
+		xs     [][]int
+		widths []int
+	}{
+		{"kerning", "AB", 100, [][]int{{0, 3}}, []int{7}},
+		{"words", "AB gA", 100, [][]int{{0, 3, 7, 9, 13}}, []int{17}},
+		{"wrap", "AB gA", 10, [][]int{{0, 3}, {0, 4}}, []int{7, 8}},
+		{"long word is not split", "ABABAB", 5, [][]int{{0, 3, 7, 10, 14, 17}}, []int{21}},
+		{"trailing space", "AB ", 100, [][]int{{0, 3}}, []int{7}},
+		{"line break", "A\nB", 100, [][]int{{0}, {0}}, []int{4, 4}},
+		{"literal line break", `A\nB`, 100, [][]int{{0}, {0}}, []int{4, 4}},
+		{"missing glyph", "AÿB", 100, [][]int{{0, 4, 7}}, []int{11}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := f.layout(tt.text, tt.width)
+			var xs [][]int
+			var widths []int
+			for _, line := range lines {
+				var lx []int
+				for _, item := range line.Items {
+					lx = append(lx, item.X)
+				}
+				xs = append(xs, lx)
+				widths = append(widths, line.Width)
+			}
+			if !reflect.DeepEqual(xs, tt.xs) || !reflect.DeepEqual(widths, tt.widths) {
+				t.Errorf("layout(%q, %v) = %v widths %v, want %v widths %v", tt.text, tt.width, xs, widths, tt.xs, tt.widths)
+			}
+		})
+	}
+}
+
+// testGlyphs holds glyph alpha masks of the test font, "#" is opaque and "+" is half transparent.
+var testGlyphs = map[rune][]string{
+	'A': {"#.#", "###", "#.#", "#+#", "#.#"},
+	'B': {"##.", "#+#", "##.", "#.#", "##+"},
+	'g': {"###", "#.#", "###", "..#", "..#", "##."},
+}
+
+func TestRenderTextBox(t *testing.T) {
+	f, err := loadTextFont("testdata/font")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type glyphAt struct {
+		r    rune
+		x, y int
+	}
+	// Box point is at (20, 20), glyph positions are top left corners of the glyph images.
+	tests := []struct {
+		name             string
+		text             string
+		w, h             int
+		centerX, centerY bool
+		glyphs           []glyphAt
+	}{
+		{"top left", "AB gA", 100, 20, false, false, []glyphAt{{'A', 20, 21}, {'B', 23, 21}, {'g', 29, 22}, {'A', 33, 21}}},
+		{"centered", "AB gA", 100, 20, true, true, []glyphAt{{'A', 12, 17}, {'B', 15, 17}, {'g', 21, 18}, {'A', 25, 17}}},
+		{"wrapped", "AB gA BA", 10, 30, true, true, []glyphAt{
+			{'A', 17, 9}, {'B', 20, 9},
+			{'g', 16, 18}, {'A', 20, 17},
+			{'B', 16, 25}, {'A', 20, 25},
+		}},
+		{"wrapped top left", "AB gA BA", 10, 30, false, false, []glyphAt{
+			{'A', 20, 21}, {'B', 23, 21},
+			{'g', 20, 30}, {'A', 24, 29},
+			{'B', 20, 37}, {'A', 24, 37},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := make(map[image.Point]uint8)
+			for _, g := range tt.glyphs {
+				for y, row := range testGlyphs[g.r] {
+					for x, c := range row {
+						switch c {
+						case '#':
+							want[image.Pt(g.x+x, g.y+y)] = 255
+						case '+':
+							want[image.Pt(g.x+x, g.y+y)] = 128
+						}
+					}
+				}
+			}
+
+			dst := image.NewRGBA(image.Rect(0, 0, 48, 48))
+			f.RenderTextBox(dst, 20, 20, tt.w, tt.h, tt.centerX, tt.centerY, tt.text)
+			for y := 0; y < 48; y++ {
+				for x := 0; x < 48; x++ {
+					if a := dst.RGBAAt(x, y).A; a != want[image.Pt(x, y)] {
+						t.Fatalf("pixel (%v, %v) alpha = %v, want %v", x, y, a, want[image.Pt(x, y)])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParseInlineIcon(t *testing.T) {
+	tests := []struct {
+		text  string
+		name  string
+		frame int
+		next  int
+	}{
+		{"£GFX_icon rest", "GFX_icon", 1, 9},
+		{"£icon|3 rest", "icon", 3, 7},
+		{"£icon|12", "icon", 12, 8},
+		{"£icon£rest", "icon", 1, 6},
+		{"£icon|2£rest", "icon", 2, 8},
+		{"£icon|x", "icon", 1, 5},
+		{"£pol_icon.", "pol_icon", 1, 9},
+		{"£", "", 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			name, frame, next := parseInlineIcon([]rune(tt.text), 1)
+			if name != tt.name || frame != tt.frame || next != tt.next {
+				t.Errorf("got %q, %v, %v, want %q, %v, %v", name, frame, next, tt.name, tt.frame, tt.next)
+			}
+		})
+	}
+
+	// £c£d is closed by the second £, so d is text.
+	got := inlineIconNames("£GFX_a and £b|2 text £c£d £")
+	want := []string{"GFX_a", "b", "GFX_b", "c", "GFX_c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("inlineIconNames() = %v, want %v", got, want)
+	}
+}
+
+func TestRenderInlineIcon(t *testing.T) {
+	f, err := loadTextFont("testdata/font")
+	if err != nil {
+		t.Fatal(err)
+	}
+	red := color.RGBA{255, 0, 0, 255}
+	src := image.NewRGBA(image.Rect(0, 0, 6, 3))
+	draw.Draw(src, src.Bounds(), &image.Uniform{red}, image.ZP, draw.Src)
+	gfxMap = map[string]SpriteType{"GFX_icon": {Name: "GFX_icon", TextureFile: writePNG(t, "icon.png", src), NoOfFrames: 2}}
+	defer func() {
+		gfxMap = make(map[string]SpriteType)
+		locWarnings = make(map[string]bool)
+	}()
+	locWarnings = make(map[string]bool)
+
+	// Icon frame is 3x3, it is a part of the word and stands on the baseline.
+	lines := f.layout("A£icon|2B £missing", 100)
+	if len(lines) != 1 || len(lines[0].Items) != 3 || lines[0].Width != 11 {
+		t.Fatalf("got %+v", lines)
+	}
+	if item := lines[0].Items[1]; item.Icon == nil || item.X != 4 || item.Icon.Bounds().Dx() != 3 {
+		t.Errorf("got icon item %+v", item)
+	}
+	if lines[0].Items[2].X != 7 {
+		t.Errorf("got B at %v, want 7", lines[0].Items[2].X)
+	}
+	if !locWarnings[`inline icon "£missing" not found`] {
+		t.Errorf("got warnings %v", locWarnings)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	f.RenderTextBox(dst, 0, 0, 100, 20, false, false, "A£icon")
+	for y := 0; y < 8; y++ {
+		for x := 4; x < 7; x++ {
+			// Base is at 6, so the icon takes rows 3 to 5.
+			if want := y >= 3 && y < 6; (dst.RGBAAt(x, y) == red) != want {
+				t.Errorf("pixel (%v, %v) = %v, icon drawn %v", x, y, dst.RGBAAt(x, y), want)
+			}
+		}
+	}
+}
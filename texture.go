@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ftrvxmtrx/tga"
+	_ "github.com/malashin/dds"
+)
+
+// textureExtensions are tried in this order when referenced texture file is missing.
+var textureExtensions = []string{".dds", ".tga", ".png"}
+
+var pngMagic = []byte("\x89PNG\r\n\x1a\n")
+var ddsMagic = []byte("DDS ")
+
+// findTexture returns path to existing texture file.
+// Texture is looked up in every declared mod/game folder, last one first,
+// then files with the same name and other texture extensions are tried.
+func findTexture(path string) (string, error) {
+	texture := path
+	for _, p := range modPaths {
+		texture = strings.TrimPrefix(texture, p)
+	}
+
+	candidates := []string{path}
+	for i := len(modPaths) - 1; i >= 0; i-- {
+		candidates = append(candidates, filepath.Join(modPaths[i], texture))
+	}
+
+	for _, ext := range append([]string{""}, textureExtensions...) {
+		for _, c := range candidates {
+			if ext != "" {
+				if strings.EqualFold(filepath.Ext(c), ext) {
+					continue
+				}
+				c = strings.TrimSuffix(c, filepath.Ext(c)) + ext
+			}
+			if _, err := os.Stat(c); err == nil {
+				return c, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("texture file \"" + path + "\" not found")
+}
+
+// loadTexture returns decoded texture from the texture cache, decoding it on the first use.
+func loadTexture(path string) (image.Image, error) {
+	if img, ok := textureCache.get(path); ok {
+		return img, nil
+	}
+	img, err := decodeTexture(path)
+	if err != nil {
+		return nil, err
+	}
+	textureCache.add(path, img)
+	return img, nil
+}
+
+// decodeTexture decodes DDS, TGA or PNG image.
+// Format is detected from the file header, TGA has no magic bytes, so it is only accepted by extension.
+func decodeTexture(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header, _ := r.Peek(len(pngMagic))
+
+	var img image.Image
+	switch {
+	case bytes.HasPrefix(header, ddsMagic):
+		var b []byte
+		b, err = io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		img, err = decodeDDS(bytes.NewReader(b))
+		if err != nil {
+			// Older decoder registered by malashin/dds might still know the format, its error is less descriptive though.
+			if fallback, _, fallbackErr := image.Decode(bytes.NewReader(b)); fallbackErr == nil {
+				return fallback, nil
+			}
+		}
+	case bytes.HasPrefix(header, pngMagic):
+		img, err = png.Decode(r)
+	case strings.EqualFold(filepath.Ext(path), ".tga"):
+		img, err = tga.Decode(r)
+	default:
+		return nil, fmt.Errorf("unsupported texture format of \"" + filepath.Base(path) + "\", only DDS, TGA and PNG are supported")
+	}
+	return img, err
+}